@@ -1,20 +1,30 @@
 package cmd
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 	"tipimate/internal/api"
+	"tipimate/internal/constants"
+	"tipimate/internal/database"
+	"tipimate/internal/rules"
+	"tipimate/internal/semver"
 	"tipimate/internal/types"
 	"tipimate/internal/utils"
 
 	"github.com/briandowns/spinner"
 	"github.com/fatih/color"
 	"github.com/go-playground/validator/v10"
+	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 )
 
 var s = spinner.New(spinner.CharSets[9], 100*time.Millisecond)
@@ -24,13 +34,21 @@ var checkCmd = &cobra.Command{
 	Short: "Check for updates on your runtipi server",
 	Long:  "Check for app updates on your runtipi server from your terminal",
 	Run: func(cmd *cobra.Command, args []string) {
-		s.Suffix = " Getting apps with updates..."
-		s.Start()
-
 		var config types.CheckConfig
 		err := viper.Unmarshal(&config)
 		handleErrorSpinner(err, "Failed to parse config")
 
+		// Scripts get plain output, the spinner and colours are only for terminals
+		interactive := isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
+		if !interactive {
+			color.NoColor = true
+		}
+
+		if interactive && config.Output == "text" {
+			s.Suffix = " Getting apps with updates..."
+			s.Start()
+		}
+
 		err = validator.New().Struct(config)
 		handleErrorSpinner(err, "Failed to validate config")
 
@@ -52,40 +70,211 @@ var checkCmd = &cobra.Command{
 		appstores, err := api.GetAppstores()
 		handleErrorSpinner(err, "Failed to get appstores")
 
+		ignoreRules := getCheckRules(config.DatabasePath)
+
 		s.Stop()
 
-		updatesAvailable := false
+		results := []checkApp{}
+		ignored := 0
 
 		for _, app := range apps.Installed {
+			_, slug := utils.SplitURN(app.Info.Urn)
+
+			appstore := utils.GetAppstore(appstores.Appstores, slug)
+			if appstore == nil {
+				appstore = &types.RuntipiAppstore{Name: "Unknown Appstore", Slug: slug}
+			}
+
+			checkedApp := types.App{
+				Urn:                  app.Info.Urn,
+				Name:                 app.Info.Name,
+				Version:              app.App.Version,
+				LatestVersion:        app.Metadata.LatestVersion,
+				DockerVersion:        app.Metadata.LatestDockerVersion,
+				CurrentDockerVersion: app.Info.Version,
+				Appstore:             *appstore,
+				Instance:             constants.DefaultInstance,
+				RuntipiUrl:           config.RuntipiUrl,
+			}
+
 			// If app has zeroed verions, ignore it
-			if app.Metadata.LatestDockerVersion == "0.0.0" || app.Metadata.LatestVersion == 0 {
-				continue
+			zeroed := app.Metadata.LatestDockerVersion == "0.0.0" || app.Metadata.LatestVersion == 0
+			updateAvailable := !zeroed && app.App.Version < app.Metadata.LatestVersion
+			ruleIgnored := updateAvailable && rules.Match(ignoreRules, &checkedApp) != nil
+
+			if ruleIgnored {
+				ignored++
 			}
 
-			// Check if app is up to date
-			if app.App.Version < app.Metadata.LatestVersion {
-				updatesAvailable = true
-				_, slug := utils.SplitURN(app.Info.Urn)
-				appstore := utils.GetAppstore(appstores.Appstores, slug)
-				if appstore == nil {
-					fmt.Printf("%s Update available for the app %s from the %s appstore to version %s (%d)!\n", color.GreenString("↻"), app.Info.Name, "Unknown Appstore", app.Metadata.LatestDockerVersion, app.Metadata.LatestVersion)
-					continue
-				}
-				fmt.Printf("%s Update available for the app %s from the %s appstore to version %s (%d)!\n", color.GreenString("↻"), app.Info.Name, appstore.Name, app.Metadata.LatestDockerVersion, app.Metadata.LatestVersion)
+			if !config.All && (!updateAvailable || ruleIgnored) {
+				continue
 			}
+
+			results = append(results, newCheckApp(&checkedApp, updateAvailable, ruleIgnored))
 		}
 
-		if !updatesAvailable {
-			fmt.Printf("%s All apps are up to date!\n", color.GreenString("✔"))
+		switch config.Output {
+		case "json":
+			printCheckJson(results)
+		case "yaml":
+			printCheckYaml(results)
+		case "table":
+			printCheckTable(results)
+		case "markdown":
+			printCheckMarkdown(results)
+		case "csv":
+			printCheckCsv(results)
+		default:
+			printCheckText(results, ignored)
 		}
 	},
 }
 
+type checkApp struct {
+	Urn                  string `json:"urn" yaml:"urn"`
+	Name                 string `json:"name" yaml:"name"`
+	Appstore             string `json:"appstore" yaml:"appstore"`
+	Version              int    `json:"version" yaml:"version"`
+	LatestVersion        int    `json:"latestVersion" yaml:"latestVersion"`
+	CurrentDockerVersion string `json:"currentDockerVersion" yaml:"currentDockerVersion"`
+	DockerVersion        string `json:"dockerVersion" yaml:"dockerVersion"`
+	UpdateClass          string `json:"updateClass" yaml:"updateClass"`
+	UpdateAvailable      bool   `json:"updateAvailable" yaml:"updateAvailable"`
+	Ignored              bool   `json:"ignored" yaml:"ignored"`
+	AppUrl               string `json:"appUrl" yaml:"appUrl"`
+}
+
+var checkColumns = []string{"URN", "NAME", "APPSTORE", "VERSION", "LATEST VERSION", "CURRENT DOCKER VERSION", "DOCKER VERSION", "CLASS", "UPDATE", "IGNORED", "URL"}
+
+func newCheckApp(app *types.App, updateAvailable bool, ignored bool) checkApp {
+	updateClass := ""
+	if updateAvailable {
+		updateClass = semver.Classify(app.CurrentDockerVersion, app.DockerVersion)
+	}
+
+	return checkApp{
+		Urn:                  app.Urn,
+		Name:                 app.Name,
+		Appstore:             app.Appstore.Name,
+		Version:              app.Version,
+		LatestVersion:        app.LatestVersion,
+		CurrentDockerVersion: app.CurrentDockerVersion,
+		DockerVersion:        app.DockerVersion,
+		UpdateClass:          updateClass,
+		UpdateAvailable:      updateAvailable,
+		Ignored:              ignored,
+		AppUrl:               utils.GetAppUrl(app),
+	}
+}
+
+func (app *checkApp) columns() []string {
+	return []string{app.Urn, app.Name, app.Appstore, strconv.Itoa(app.Version), strconv.Itoa(app.LatestVersion), app.CurrentDockerVersion, app.DockerVersion, app.UpdateClass, strconv.FormatBool(app.UpdateAvailable), strconv.FormatBool(app.Ignored), app.AppUrl}
+}
+
+func printCheckText(results []checkApp, ignored int) {
+	updatesAvailable := false
+
+	for _, app := range results {
+		if !app.UpdateAvailable || app.Ignored {
+			continue
+		}
+		updatesAvailable = true
+		fmt.Printf("%s Update available for the app %s from the %s appstore to version %s (%d)!\n", color.GreenString("↻"), app.Name, app.Appstore, app.DockerVersion, app.LatestVersion)
+	}
+
+	if !updatesAvailable {
+		fmt.Printf("%s All apps are up to date!\n", color.GreenString("✔"))
+	}
+
+	if ignored > 0 {
+		fmt.Printf("%s %d updates ignored by ignore rules\n", color.YellowString("-"), ignored)
+	}
+}
+
+func printCheckJson(results []checkApp) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(results)
+	handleErrorSpinner(err, "Failed to encode apps")
+}
+
+func printCheckYaml(results []checkApp) {
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	err := encoder.Encode(results)
+	handleErrorSpinner(err, "Failed to encode apps")
+}
+
+func printCheckTable(results []checkApp) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(checkColumns, "\t"))
+
+	for _, app := range results {
+		fmt.Fprintln(writer, strings.Join(app.columns(), "\t"))
+	}
+
+	writer.Flush()
+}
+
+func printCheckMarkdown(results []checkApp) {
+	// Pipes would end the cell early
+	escape := strings.NewReplacer("|", "\\|")
+
+	fmt.Printf("| %s |\n", strings.Join(checkColumns, " | "))
+	fmt.Printf("|%s\n", strings.Repeat(" --- |", len(checkColumns)))
+
+	for _, app := range results {
+		columns := app.columns()
+		for i := range columns {
+			columns[i] = escape.Replace(columns[i])
+		}
+		fmt.Printf("| %s |\n", strings.Join(columns, " | "))
+	}
+}
+
+func printCheckCsv(results []checkApp) {
+	writer := csv.NewWriter(os.Stdout)
+
+	header := []string{}
+	for _, column := range checkColumns {
+		header = append(header, strings.ToLower(strings.ReplaceAll(column, " ", "_")))
+	}
+	writer.Write(header)
+
+	for _, app := range results {
+		writer.Write(app.columns())
+	}
+
+	writer.Flush()
+	handleErrorSpinner(writer.Error(), "Failed to write csv")
+}
+
+func getCheckRules(databasePath string) []database.Rules {
+	// The check command works without a database, only use rules when the server created one
+	if _, err := os.Stat(databasePath); err != nil {
+		return nil
+	}
+
+	// The server owns the database, so it is read as is instead of being migrated
+	db, err := database.OpenDatabase(databasePath)
+	handleErrorSpinner(err, "Failed to open database")
+
+	if !db.Migrator().HasTable(&database.Rules{}) {
+		return nil
+	}
+
+	ignoreRules, err := rules.GetRules(db, false)
+	handleErrorSpinner(err, "Failed to get ignore rules")
+
+	return ignoreRules
+}
+
 func handleErrorSpinner(err error, msg string) {
 	if err != nil {
 		s.Stop()
-		fmt.Printf("%s %s\n", color.RedString("✘"), msg)
-		fmt.Printf("Error: %s\n", err)
+		// Errors go to stderr so scripts never parse them as output
+		fmt.Fprintf(os.Stderr, "%s %s\n", color.RedString("✘"), msg)
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
 		os.Exit(1)
 	}
 }
@@ -98,9 +287,9 @@ func init() {
 	checkCmd.Flags().String("runtipi-url", "", "Runtipi server URL")
 	checkCmd.Flags().String("jwt-secret", "", "JWT secret")
 	checkCmd.Flags().Bool("insecure", false, "Ignore self-signed certificates")
-
-	// Bind flags to viper
-	viper.BindPFlags(checkCmd.Flags())
+	checkCmd.Flags().String("database-path", "tipimate.db", "Database path, used for ignore rules")
+	checkCmd.Flags().String("output", "text", "Output format (text, json, yaml, table, markdown, csv)")
+	checkCmd.Flags().Bool("all", false, "Include apps without an update and ignored updates")
 
 	rootCmd.AddCommand(checkCmd)
 }
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+type healthcheckResponse struct {
+	Status   string `json:"status"`
+	Database string `json:"database"`
+	Servers  []struct {
+		Instance  string `json:"instance"`
+		Ready     bool   `json:"ready"`
+		LastError string `json:"lastError"`
+	} `json:"servers"`
+}
+
+var healthcheckCmd = &cobra.Command{
+	Use:   "healthcheck",
+	Short: "Check the health of a running tipimate server",
+	Long:  "Query the health endpoints of a running tipimate server and exit with a non-zero code when it is unhealthy, meant for the docker HEALTHCHECK",
+	Run: func(cmd *cobra.Command, args []string) {
+		baseUrl := viper.GetString("url")
+		if baseUrl == "" {
+			baseUrl = getHealthUrl()
+		}
+
+		path := "/readyz"
+		if viper.GetBool("live") {
+			path = "/healthz"
+		}
+
+		client := http.Client{Timeout: viper.GetDuration("timeout")}
+
+		res, err := client.Get(baseUrl + path)
+		handleErrorCommand(err, "Failed to reach tipimate")
+		defer res.Body.Close()
+
+		var health healthcheckResponse
+		err = json.NewDecoder(res.Body).Decode(&health)
+		handleErrorCommand(err, "Failed to decode the health response")
+
+		if res.StatusCode == http.StatusOK {
+			fmt.Printf("%s Tipimate is healthy\n", color.GreenString("✔"))
+			return
+		}
+
+		fmt.Printf("%s Tipimate is unhealthy\n", color.RedString("✘"))
+
+		if health.Database != "" && health.Database != "ok" {
+			fmt.Printf("- Database: %s\n", health.Database)
+		}
+
+		for _, server := range health.Servers {
+			if !server.Ready {
+				fmt.Printf("- Server %s has no recent successful check: %s\n", server.Instance, orDash(server.LastError))
+			}
+		}
+
+		os.Exit(1)
+	},
+}
+
+func getHealthUrl() string {
+	address := viper.GetString("health-address")
+	if address == "" {
+		address = viper.GetString("http-address")
+	}
+
+	if address == "" {
+		handleErrorCommand(errors.New("set --url, health-address or http-address"), "No health endpoint configured")
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	handleErrorCommand(err, "Invalid address "+address)
+
+	// Servers listening on every interface are reached through loopback
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+func init() {
+	healthcheckCmd.Flags().String("url", "", "Base URL of the tipimate server (defaults to the health or HTTP address)")
+	healthcheckCmd.Flags().Bool("live", false, "Only check that the server is running instead of its readiness")
+	healthcheckCmd.Flags().Duration("timeout", 5*time.Second, "Request timeout")
+
+	rootCmd.AddCommand(healthcheckCmd)
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"tipimate/internal/database"
+	"tipimate/internal/history"
+	"tipimate/internal/utils"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+type historyEntry struct {
+	Time            time.Time `json:"time"`
+	Type            string    `json:"type"`
+	Instance        string    `json:"instance"`
+	ServerName      string    `json:"serverName,omitempty"`
+	Urn             string    `json:"urn"`
+	Name            string    `json:"name"`
+	Appstore        string    `json:"appstore"`
+	Version         int       `json:"version"`
+	PreviousVersion int       `json:"previousVersion,omitempty"`
+	LatestVersion   int       `json:"latestVersion"`
+	DockerVersion   string    `json:"dockerVersion"`
+	Target          string    `json:"target,omitempty"`
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the update history",
+	Long:  "Show when updates were detected, notified and applied, newest first",
+	Run: func(cmd *cobra.Command, args []string) {
+		output := viper.GetString("output")
+		if output != "table" && output != "json" {
+			handleErrorCommand(errors.New("output must be table or json"), "Invalid output format "+output)
+		}
+
+		filter := history.Filter{
+			Urn:    viper.GetString("urn"),
+			Server: viper.GetString("server"),
+			Types:  viper.GetStringSlice("type"),
+			Since:  parseHistoryTime(viper.GetString("since")),
+			Until:  parseHistoryTime(viper.GetString("until")),
+			Limit:  viper.GetInt("limit"),
+		}
+
+		db := openDatabase()
+
+		entries, err := history.GetHistory(db, filter)
+		handleErrorCommand(err, "Failed to get history")
+
+		if output == "json" {
+			printHistoryJson(entries)
+			return
+		}
+
+		if len(entries) == 0 {
+			fmt.Printf("%s No history entries!\n", color.GreenString("✔"))
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "TIME\tTYPE\tSERVER\tURN\tVERSION\tDOCKER VERSION\tTARGET")
+
+		for _, entry := range entries {
+			// Applied entries show the installed version change, the others the pending update
+			version := fmt.Sprintf("%d → %d", entry.Version, entry.LatestVersion)
+			if entry.Type == history.TypeApplied {
+				version = fmt.Sprintf("%d → %d", entry.PreviousVersion, entry.Version)
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", entry.CreatedAt.Local().Format(time.DateTime), entry.Type, entry.Instance, entry.Urn, version, entry.DockerVersion, orDash(entry.Target))
+		}
+
+		writer.Flush()
+	},
+}
+
+func printHistoryJson(entries []database.History) {
+	output := []historyEntry{}
+
+	for _, entry := range entries {
+		output = append(output, historyEntry{
+			Time:            entry.CreatedAt,
+			Type:            entry.Type,
+			Instance:        entry.Instance,
+			ServerName:      entry.ServerName,
+			Urn:             entry.Urn,
+			Name:            entry.Name,
+			Appstore:        entry.Appstore,
+			Version:         entry.Version,
+			PreviousVersion: entry.PreviousVersion,
+			LatestVersion:   entry.LatestVersion,
+			DockerVersion:   entry.DockerVersion,
+			Target:          entry.Target,
+		})
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(output)
+	handleErrorCommand(err, "Failed to encode history")
+}
+
+func parseHistoryTime(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+
+	// Durations are relative to now, e.g. 7d is a week ago
+	duration, err := utils.ParseDuration(value)
+	if err == nil {
+		return time.Now().Add(-duration)
+	}
+
+	for _, layout := range []string{time.RFC3339, time.DateTime, time.DateOnly} {
+		parsed, err := time.ParseInLocation(layout, value, time.Local)
+		if err == nil {
+			return parsed
+		}
+	}
+
+	handleErrorCommand(fmt.Errorf("expected a duration, a date or a date and time, got %q", value), "Invalid time "+value)
+	return time.Time{}
+}
+
+func init() {
+	historyCmd.Flags().String("database-path", "tipimate.db", "Database path")
+	historyCmd.Flags().String("urn", "", "Only show this app, globs like \"*:official\" are supported")
+	historyCmd.Flags().String("server", "", "Only show this server (instance or server name)")
+	historyCmd.Flags().StringSlice("type", []string{}, "Only show these entry types ("+strings.Join([]string{history.TypeDetected, history.TypeNotified, history.TypeApplied}, ", ")+")")
+	historyCmd.Flags().String("since", "", "Only show entries after this time (e.g. 7d, 2025-01-31 or 2025-01-31 18:00:00)")
+	historyCmd.Flags().String("until", "", "Only show entries before this time (same formats as --since)")
+	historyCmd.Flags().Int("limit", 100, "Maximum number of entries (0 shows everything)")
+	historyCmd.Flags().String("output", "table", "Output format (table, json)")
+
+	rootCmd.AddCommand(historyCmd)
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+	"tipimate/internal/database"
+	"tipimate/internal/rules"
+	"tipimate/internal/utils"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var ignoreCmd = &cobra.Command{
+	Use:   "ignore",
+	Short: "Manage ignore rules",
+	Long:  "Ignore updates for apps, appstores or specific versions, the rules are stored in the tipimate database and used by both the server and check commands",
+}
+
+var ignoreAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add an ignore rule",
+	Run: func(cmd *cobra.Command, args []string) {
+		rule := database.Rules{
+			Urn:      viper.GetString("urn"),
+			Appstore: viper.GetString("appstore"),
+			Server:   viper.GetString("server"),
+			Version:  viper.GetString("version"),
+			Reason:   viper.GetString("reason"),
+		}
+
+		if duration := viper.GetString("for"); duration != "" {
+			until := parseUntil(duration)
+			rule.Until = &until
+		}
+
+		addRule(&rule)
+	},
+}
+
+var ignoreListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List ignore rules",
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openDatabase()
+
+		ignoreRules, err := rules.GetRules(db, viper.GetBool("all"))
+		handleErrorCommand(err, "Failed to get ignore rules")
+
+		if len(ignoreRules) == 0 {
+			fmt.Printf("%s No ignore rules!\n", color.GreenString("✔"))
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tKIND\tURN\tAPPSTORE\tSERVER\tVERSION\tUNTIL\tREASON")
+
+		for _, rule := range ignoreRules {
+			until := "-"
+			if rule.Until != nil {
+				until = rule.Until.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", rule.ID, rules.GetKind(&rule), orDash(rule.Urn), orDash(rule.Appstore), orDash(rule.Server), orDash(rule.Version), until, rule.Reason)
+		}
+
+		writer.Flush()
+	},
+}
+
+var ignoreDeleteCmd = &cobra.Command{
+	Use:   "delete id...",
+	Short: "Delete ignore rules",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openDatabase()
+
+		count, err := rules.DeleteRules(db, parseIds(args))
+		handleErrorCommand(err, "Failed to delete ignore rules")
+
+		fmt.Printf("%s Deleted %d ignore rules\n", color.GreenString("✔"), count)
+	},
+}
+
+var snoozeCmd = &cobra.Command{
+	Use:   "snooze urn",
+	Short: "Snooze updates for an app",
+	Long:  "Stop notifying and auto updating an app for a while, once the snooze ends pending updates are notified again",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		until := parseUntil(viper.GetString("for"))
+
+		rule := database.Rules{
+			Urn:     args[0],
+			Server:  viper.GetString("server"),
+			Version: viper.GetString("version"),
+			Until:   &until,
+			Reason:  viper.GetString("reason"),
+		}
+
+		addRule(&rule)
+	},
+}
+
+func addRule(rule *database.Rules) {
+	db := openDatabase()
+
+	err := rules.AddRule(db, rule)
+	handleErrorCommand(err, "Failed to add ignore rule")
+
+	if rule.Until != nil {
+		fmt.Printf("%s Added rule %d, updates are snoozed until %s\n", color.GreenString("✔"), rule.ID, rule.Until.Local().Format(time.DateTime))
+		return
+	}
+
+	fmt.Printf("%s Added rule %d\n", color.GreenString("✔"), rule.ID)
+}
+
+func parseUntil(value string) time.Time {
+	duration, err := utils.ParseDuration(value)
+	handleErrorCommand(err, "Invalid duration "+value)
+	return time.Now().Add(duration)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func init() {
+	ignoreCmd.PersistentFlags().String("database-path", "tipimate.db", "Database path")
+	ignoreAddCmd.Flags().String("urn", "", "App URN, globs like \"*:official\" are supported")
+	ignoreAddCmd.Flags().String("appstore", "", "Appstore slug")
+	ignoreAddCmd.Flags().String("server", "", "Only ignore updates on this server (instance or server name)")
+	ignoreAddCmd.Flags().String("version", "", "Only ignore this version (docker or tipi version)")
+	ignoreAddCmd.Flags().String("for", "", "Only ignore for this long (e.g. 7d, 12h)")
+	ignoreAddCmd.Flags().String("reason", "", "Why the updates are ignored")
+	ignoreListCmd.Flags().Bool("all", false, "Include expired snoozes")
+
+	ignoreCmd.AddCommand(ignoreAddCmd)
+	ignoreCmd.AddCommand(ignoreListCmd)
+	ignoreCmd.AddCommand(ignoreDeleteCmd)
+
+	snoozeCmd.Flags().String("database-path", "tipimate.db", "Database path")
+	snoozeCmd.Flags().String("for", "7d", "How long to snooze the app (e.g. 7d, 12h)")
+	snoozeCmd.Flags().String("server", "", "Only snooze the app on this server (instance or server name)")
+	snoozeCmd.Flags().String("version", "", "Only snooze this version (docker or tipi version)")
+	snoozeCmd.Flags().String("reason", "", "Why the app is snoozed")
+
+	rootCmd.AddCommand(ignoreCmd)
+	rootCmd.AddCommand(snoozeCmd)
+}
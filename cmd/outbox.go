@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+	"tipimate/internal/alerts"
+	"tipimate/internal/database"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var outboxCmd = &cobra.Command{
+	Use:   "outbox",
+	Short: "Inspect the notification outbox",
+	Long:  "Inspect, retry and delete the notifications the tipimate server has queued for delivery",
+}
+
+var outboxListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List queued notifications",
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openDatabase()
+
+		query := db.Order("id")
+		if status := viper.GetString("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+
+		var notifications []database.Notifications
+		res := query.Find(&notifications)
+		handleErrorCommand(res.Error, "Failed to get notifications")
+
+		if len(notifications) == 0 {
+			fmt.Printf("%s The outbox is empty!\n", color.GreenString("✔"))
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tTARGET\tTYPE\tINSTANCE\tURN\tSTATUS\tATTEMPTS\tNEXT ATTEMPT\tLAST ERROR")
+
+		for _, notification := range notifications {
+			nextAttempt := notification.DeliverAt.Local().Format(time.DateTime)
+			if notification.Status != alerts.OutboxPending {
+				nextAttempt = "-"
+			}
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", notification.ID, notification.Target, notification.Type, notification.Instance, notification.Urn, notification.Status, notification.Attempts, nextAttempt, notification.LastError)
+		}
+
+		writer.Flush()
+	},
+}
+
+var outboxRetryCmd = &cobra.Command{
+	Use:   "retry [id...]",
+	Short: "Retry notifications",
+	Long:  "Queue notifications for immediate delivery, without ids every failed notification is retried",
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openDatabase()
+
+		count, err := alerts.RetryNotifications(db, parseIds(args))
+		handleErrorCommand(err, "Failed to retry notifications")
+
+		fmt.Printf("%s Queued %d notifications for delivery, they will be sent by the running server\n", color.GreenString("✔"), count)
+	},
+}
+
+var outboxDeleteCmd = &cobra.Command{
+	Use:   "delete id...",
+	Short: "Delete notifications",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openDatabase()
+
+		count, err := alerts.DeleteNotifications(db, parseIds(args))
+		handleErrorCommand(err, "Failed to delete notifications")
+
+		fmt.Printf("%s Deleted %d notifications\n", color.GreenString("✔"), count)
+	},
+}
+
+func init() {
+	outboxCmd.PersistentFlags().String("database-path", "tipimate.db", "Database path")
+	outboxListCmd.Flags().String("status", "", "Only show notifications with this status (pending, sent, failed)")
+
+	outboxCmd.AddCommand(outboxListCmd)
+	outboxCmd.AddCommand(outboxRetryCmd)
+	outboxCmd.AddCommand(outboxDeleteCmd)
+
+	rootCmd.AddCommand(outboxCmd)
+}
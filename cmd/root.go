@@ -3,14 +3,24 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"tipimate/internal/database"
 
+	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "tipimate",
 	Short: "App update notifications for your runtipi server",
 	Long:  "Tipimate is a simple tool that sends you notification when your runtipi apps have an available update",
+	// Several commands share flag names, so only the flags of the command being run are bound
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlags(cmd.Flags())
+	},
 }
 
 func Execute() {
@@ -20,3 +30,58 @@ func Execute() {
 		os.Exit(1)
 	}
 }
+
+func initConfig() {
+	// Config files are optional, flags and environment variables are enough for a single instance
+	configFile := viper.GetString("config")
+	if configFile == "" {
+		return
+	}
+
+	viper.SetConfigFile(configFile)
+
+	err := viper.ReadInConfig()
+	if err != nil {
+		fmt.Printf("Failed to read config file, error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func openDatabase() *gorm.DB {
+	db, err := database.InitDatabase(viper.GetString("database-path"))
+	handleErrorCommand(err, "Failed to open database")
+	return db
+}
+
+func parseIds(args []string) []uint {
+	ids := []uint{}
+
+	for _, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		handleErrorCommand(err, "Invalid id "+arg)
+		ids = append(ids, uint(id))
+	}
+
+	return ids
+}
+
+func handleErrorCommand(err error, msg string) {
+	if err != nil {
+		fmt.Printf("%s %s\n", color.RedString("✘"), msg)
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func init() {
+	viper.SetEnvPrefix("tipimate")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
+
+	rootCmd.PersistentFlags().String("config", "", "Config file path (yaml, json or toml)")
+
+	// Bind flags to viper
+	viper.BindPFlags(rootCmd.PersistentFlags())
+
+	cobra.OnInitialize(initConfig)
+}
@@ -1,24 +1,33 @@
 package cmd
 
 import (
+	"errors"
+	"math/rand"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"tipimate/internal/alerts"
-	"tipimate/internal/api"
 	"tipimate/internal/constants"
 	"tipimate/internal/database"
+	"tipimate/internal/matcher"
+	"tipimate/internal/monitor"
+	"tipimate/internal/rules"
 	"tipimate/internal/types"
+	"tipimate/internal/updater"
 	"tipimate/internal/utils"
+	"tipimate/internal/web"
 
 	"github.com/containrrr/shoutrrr/pkg/router"
 	"github.com/go-playground/validator/v10"
+	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"gorm.io/gorm"
 )
 
 var serverCmd = &cobra.Command{
@@ -40,126 +49,424 @@ var serverCmd = &cobra.Command{
 
 		log.Debug().Interface("config", config).Msg("Dumping configuration")
 
+		targets := getNotificationTargets(config)
 		sr := router.ServiceRouter{}
-		_, err = sr.Locate(config.NotificationUrl)
-		handleError(err, "Invalid notification URL")
 
-		_, err = url.Parse(config.RuntipiUrl)
-		handleError(err, "Invalid runtipi URL")
+		for _, target := range targets {
+			_, err = sr.Locate(target.Url)
+			handleError(err, "Invalid notification URL for target "+target.Name)
+
+			err = matcher.Validate(target.Match.AppMatch)
+			handleError(err, "Invalid match rules for target "+target.Name)
+
+			err = alerts.ValidateTemplate(target.Template)
+			handleError(err, "Invalid template for target "+target.Name)
+
+			err = alerts.ValidateQuietHours(target.QuietHours)
+			handleError(err, "Invalid quiet hours for target "+target.Name)
+		}
+
+		location, err := utils.GetLocation(config.Timezone)
+		handleError(err, "Invalid timezone")
+
+		if config.SummarySchedule != "" {
+			_, err = cron.ParseStandard(config.SummarySchedule)
+			handleError(err, "Invalid summary schedule")
+		}
+
+		if config.Schedule != "" {
+			_, err = cron.ParseStandard(config.Schedule)
+			handleError(err, "Invalid check schedule")
+		}
+
+		for _, policy := range config.AutoUpdate.Policies {
+			err = matcher.Validate(policy.AppMatch)
+			handleError(err, "Invalid auto update policy")
+		}
+
+		if config.HttpAddress != "" && config.HttpToken == "" && config.HttpUsername == "" {
+			handleError(errors.New("set http-token or http-username and http-password"), "The HTTP server needs credentials")
+		}
+
+		var minAge time.Duration
+		if config.MinAge != "" {
+			minAge, err = utils.ParseDuration(config.MinAge)
+			handleError(err, "Invalid minimum age")
+		}
+
+		// Auto updates can wait longer (or shorter) than notifications
+		updateMinAge := minAge
+		if config.AutoUpdate.MinAge != "" {
+			updateMinAge, err = utils.ParseDuration(config.AutoUpdate.MinAge)
+			handleError(err, "Invalid auto update minimum age")
+		}
+
+		var reminderInterval, escalateAfter time.Duration
+		if config.ReminderInterval != "" {
+			reminderInterval, err = utils.ParseDuration(config.ReminderInterval)
+			handleError(err, "Invalid reminder interval")
+		}
+		if config.EscalateAfter != "" {
+			escalateAfter, err = utils.ParseDuration(config.EscalateAfter)
+			handleError(err, "Invalid escalation delay")
+		}
+
+		instances := getInstances(config)
+
+		for _, instance := range instances {
+			_, err = url.Parse(instance.RuntipiUrl)
+			handleError(err, "Invalid runtipi URL for instance "+instance.Name)
+		}
 
 		db, err := database.InitDatabase(config.DatabasePath)
 		handleError(err, "Failed to initialize database")
 
-		apiConfig := types.APIConfig{
-			RuntipiUrl: config.RuntipiUrl,
-			Secret:     config.JwtSecret,
-			Insecure:   config.Insecure,
+		instanceNames := []string{}
+		monitors := []*monitor.Monitor{}
+
+		for _, instance := range instances {
+			monitorConfig := types.MonitorConfig{
+				Instance:             instance,
+				RetryAttempts:        config.RetryAttempts,
+				RetryDelay:           config.RetryDelay,
+				UnreachableThreshold: config.Unreachable,
+				MinAge:               minAge,
+			}
+
+			instanceMonitor, err := monitor.NewMonitor(monitorConfig, db)
+			handleError(err, "Failed to create API client for instance "+instance.Name)
+			monitors = append(monitors, instanceMonitor)
+			instanceNames = append(instanceNames, instance.Name)
 		}
 
-		api, err := api.NewAPI(apiConfig)
-		handleError(err, "Failed to create API client")
+		// Forget apps from instances that are no longer configured
+		db.Unscoped().Where("instance NOT IN ?", instanceNames).Delete(&database.Apps{})
+		db.Unscoped().Where("instance NOT IN ?", instanceNames).Delete(&database.Appstores{})
+		db.Unscoped().Where("name NOT IN ?", instanceNames).Delete(&database.Instances{})
 
 		alertsConfig := types.AlertsConfig{
-			NotificationUrl: config.NotificationUrl,
-			RuntipiUrl:      config.RuntipiUrl,
-			Insecure:        config.Insecure,
-			ServerName:      config.ServerName,
+			Targets:          targets,
+			Insecure:         config.Insecure,
+			Timezone:         config.Timezone,
+			MaxAttempts:      config.MaxAttempts,
+			ReminderInterval: reminderInterval,
+			EscalateAfter:    escalateAfter,
+			EscalateTo:       config.EscalateTo,
 		}
 
-		alerts := alerts.NewAlerts(alertsConfig)
+		err = alerts.ValidateReminders(alertsConfig)
+		handleError(err, "Invalid reminders")
 
-		ticker := time.NewTicker(time.Duration(config.Interval) * time.Minute)
-		defer ticker.Stop()
+		notifier, err := alerts.NewAlerts(alertsConfig, db)
+		handleError(err, "Failed to create alerts")
 
-		for ; true; <-ticker.C {
-			log.Info().Msg("Checking for updates")
+		updaterConfig := types.UpdaterConfig{
+			AutoUpdate:  config.AutoUpdate,
+			HealthCheck: config.HealthCheck,
+			MinAge:      updateMinAge,
+		}
 
-			log.Info().Msg("Getting installed apps")
-			apps, err := api.GetInstalledApps()
-			handleError(err, "Failed to get installed apps")
+		autoUpdater, err := updater.NewUpdater(updaterConfig, monitors, db)
+		handleError(err, "Failed to create auto updater")
 
-			log.Info().Msg("Getting appstores")
-			appstores, err := api.GetAppstores()
-			handleError(err, "Failed to get appstores")
+		scheduler := cron.New(cron.WithLocation(location))
 
-			installedApps := make(map[string]bool)
-			for _, app := range apps.Installed {
-				installedApps[app.Info.Urn] = true
-			}
+		if config.SummarySchedule != "" {
+			log.Info().Str("schedule", config.SummarySchedule).Str("timezone", location.String()).Msg("Scheduling pending updates summary")
+			scheduler.AddFunc(config.SummarySchedule, func() {
+				sendSummary(monitors, autoUpdater, db, notifier)
+			})
+		}
 
-			var dbApps []database.Apps
-			db.Find(&dbApps)
+		// Checks are queued through a channel so a slow check never piles up runs
+		checks := make(chan struct{}, 1)
+		queueCheck := func() bool {
+			select {
+			case checks <- struct{}{}:
+				return true
+			default:
+				log.Debug().Msg("Check already queued, skipping")
+				return false
+			}
+		}
 
-			for _, dbApp := range dbApps {
-				if !installedApps[dbApp.Urn] {
-					log.Warn().Str("urn", dbApp.Urn).Msg("Deleting app from the database")
-					db.Unscoped().Delete(&dbApp)
-				}
+		// Only scheduled checks are spread out, checks requested through the API run right away
+		scheduledCheck := func() {
+			if config.Jitter > 0 {
+				delay := time.Duration(rand.Int63n(int64(config.Jitter)))
+				log.Info().Str("delay", delay.Round(time.Second).String()).Msg("Delaying check by jitter")
+				time.Sleep(delay)
 			}
+			queueCheck()
+		}
+
+		if config.Schedule != "" {
+			log.Info().Str("schedule", config.Schedule).Str("timezone", location.String()).Msg("Scheduling checks")
+			scheduler.AddFunc(config.Schedule, scheduledCheck)
+		} else {
+			log.Info().Int("interval", config.Interval).Msg("Scheduling checks every interval")
+			scheduler.Schedule(cron.Every(time.Duration(config.Interval)*time.Minute), cron.FuncJob(scheduledCheck))
+		}
 
-			log.Info().Msg("Comparing versions")
-			appsWithUpdates := []types.App{}
+		// Queued notifications are also sent right away in case they became due while tipimate was down
+		scheduler.AddFunc("@every 1m", notifier.SendQueued)
+		go notifier.SendQueued()
+
+		// Updates interrupted by a restart are finished before new ones start
+		go func() {
+			sendResults(notifier, autoUpdater.Resume())
+		}()
+
+		// Pending updates are retried every minute so the maintenance window is never missed
+		if config.AutoUpdate.Enabled {
+			log.Info().Str("defaultPolicy", autoUpdater.DefaultPolicy).Int("maxConcurrent", autoUpdater.MaxConcurrent).Msg("Auto updates enabled")
+			scheduler.AddFunc("@every 1m", func() {
+				runUpdates(autoUpdater, notifier)
+			})
+		}
 
-			for _, app := range apps.Installed {
-				// If app is up to date, ignore it
-				if app.App.Version == app.Metadata.LatestVersion {
-					log.Debug().Str("urn", app.Info.Urn).Msg("App is up to date, ignoring")
-					continue
-				}
+		scheduler.Start()
+		defer scheduler.Stop()
 
-				// If app has zeroed verions, ignore it
-				if app.Metadata.LatestDockerVersion == "0.0.0" || app.Metadata.LatestVersion == 0 {
-					log.Debug().Str("urn", app.Info.Urn).Msg("App has zeroed version, ignoring")
-					continue
-				}
+		httpConfig := types.HttpConfig{
+			Address:       config.HttpAddress,
+			HealthAddress: config.HealthAddress,
+			Token:         config.HttpToken,
+			Username:      config.HttpUsername,
+			Password:      config.HttpPassword,
+			MaxCheckAge:   2*getCheckInterval(config) + config.Jitter,
+		}
+
+		httpServer := web.NewServer(httpConfig, monitors, notifier, db, queueCheck)
+
+		if config.HttpAddress != "" {
+			go func() {
+				err := httpServer.Start()
+				handleError(err, "HTTP server failed")
+			}()
+		}
+
+		if config.HealthAddress != "" {
+			go func() {
+				err := httpServer.StartHealth()
+				handleError(err, "Health server failed")
+			}()
+		}
+
+		if config.RunOnStart {
+			queueCheck()
+		}
+
+		for range checks {
+			log.Info().Msg("Checking for updates")
+
+			checkEvents := rules.FilterEvents(db, autoUpdater.FilterEvents(checkInstances(monitors)))
 
-				log.Debug().Interface("app", app).Msg("App has an update")
-
-				var dbApp database.Apps
-				dbRes := db.First(&dbApp, "urn = ?", app.Info.Urn)
-
-				if dbRes.RowsAffected == 0 {
-					log.Debug().Str("urn", app.Info.Urn).Msg("App not found in database, creating new entry")
-					db.Create(&database.Apps{Urn: app.Info.Urn, Version: app.App.Version, LatestVersion: app.Metadata.LatestVersion})
-					appsWithUpdates = append(appsWithUpdates, types.App{
-						Urn:           app.Info.Urn,
-						Name:          app.Info.Name,
-						Version:       app.App.Version,
-						DockerVersion: app.Metadata.LatestDockerVersion,
-					})
-				} else {
-					log.Debug().Str("urn", app.Info.Urn).Msg("App found in database, checking versions")
-
-					if dbApp.Version != app.App.Version || dbApp.LatestVersion != app.Metadata.LatestVersion {
-						log.Debug().Str("urn", app.Info.Urn).Msg("Updating app in database")
-						db.Model(&dbApp).Updates(database.Apps{LatestVersion: app.Metadata.LatestVersion, Version: app.App.Version})
-						appsWithUpdates = append(appsWithUpdates, types.App{
-							Urn:           app.Info.Urn,
-							Name:          app.Info.Name,
-							Version:       app.App.Version,
-							DockerVersion: app.Metadata.LatestDockerVersion,
-						})
+			// Applied updates and lifecycle events are only notified on request,
+			// applied auto updates already send their own result
+			events := []types.Event{}
+			for _, event := range checkEvents {
+				switch {
+				case event.Type == types.EventApplied:
+					if config.NotifyApplied && !autoUpdater.IsAutoUpdate(&event.App) {
+						events = append(events, event)
 					}
+				case isLifecycleEvent(event.Type):
+					if config.NotifyLifecycle {
+						events = append(events, event)
+					}
+				default:
+					events = append(events, event)
 				}
 			}
 
-			if len(appsWithUpdates) == 0 {
+			go verifyUpdates(autoUpdater, notifier, checkEvents)
+
+			if len(events) == 0 {
 				log.Info().Msg("No updates found")
-				continue
+			} else {
+				log.Info().Msg("Sending notifications")
+
+				err := notifier.QueueAlerts(events)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to queue alerts")
+				}
+			}
+
+			err := notifier.QueueReminders(getPendingEvents(monitors, autoUpdater, db, types.EventReminder))
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to queue reminders")
 			}
 
-			log.Info().Msg("Sending notifications")
+			notifier.SendQueued()
 
-			for _, appWithUpdate := range appsWithUpdates {
-				log.Logger.Info().Str("urn", appWithUpdate.Urn).Str("tipiVersion", strconv.Itoa(appWithUpdate.Version)).Str("dockerVersion", appWithUpdate.DockerVersion).Msg("App has an update")
-				alertErr := alerts.SendAlert(&appWithUpdate, appstores.Appstores)
-				handleError(alertErr, "Failed to send alert")
+			if config.AutoUpdate.Enabled {
+				go runUpdates(autoUpdater, notifier)
 			}
 		}
 
 	},
 }
 
+func checkInstances(monitors []*monitor.Monitor) []types.Event {
+	// Results are stored per monitor to keep notifications in config order
+	results := make([][]types.Event, len(monitors))
+	wg := sync.WaitGroup{}
+
+	for i, instanceMonitor := range monitors {
+		wg.Add(1)
+		go func(i int, instanceMonitor *monitor.Monitor) {
+			defer wg.Done()
+			events, err := instanceMonitor.Check()
+			if err != nil {
+				log.Error().Err(err).Str("instance", instanceMonitor.Instance.Name).Msg("Failed to check for updates")
+			}
+			results[i] = events
+		}(i, instanceMonitor)
+	}
+
+	wg.Wait()
+
+	events := []types.Event{}
+
+	for _, instanceEvents := range results {
+		for _, event := range instanceEvents {
+			if event.Type == types.EventUpdate {
+				log.Info().Str("instance", event.App.Instance).Str("urn", event.App.Urn).Str("tipiVersion", strconv.Itoa(event.App.LatestVersion)).Str("dockerVersion", event.App.DockerVersion).Msg("App has an update")
+			}
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+func runUpdates(autoUpdater *updater.Updater, notifier *alerts.Alerts) {
+	sendResults(notifier, autoUpdater.Run())
+}
+
+func verifyUpdates(autoUpdater *updater.Updater, notifier *alerts.Alerts, events []types.Event) {
+	sendResults(notifier, autoUpdater.VerifyApplied(events))
+}
+
+func sendResults(notifier *alerts.Alerts, events []types.Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	err := notifier.QueueAlerts(events)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to queue update results")
+	}
+
+	notifier.SendQueued()
+}
+
+func sendSummary(monitors []*monitor.Monitor, autoUpdater *updater.Updater, db *gorm.DB, notifier *alerts.Alerts) {
+	log.Info().Msg("Sending pending updates summary")
+
+	events := getPendingEvents(monitors, autoUpdater, db, types.EventSummary)
+
+	if len(events) == 0 {
+		log.Info().Msg("No pending updates, skipping summary")
+		return
+	}
+
+	err := notifier.SendSummary(events)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to send summary")
+	}
+}
+
+func getPendingEvents(monitors []*monitor.Monitor, autoUpdater *updater.Updater, db *gorm.DB, eventType string) []types.Event {
+	events := []types.Event{}
+
+	for _, instanceMonitor := range monitors {
+		apps, err := instanceMonitor.GetPendingApps()
+		if err != nil {
+			log.Error().Err(err).Str("instance", instanceMonitor.Instance.Name).Msg("Failed to get pending apps")
+			continue
+		}
+
+		for _, app := range apps {
+			if !monitor.HasMinAge(&app, instanceMonitor.MinAge) {
+				continue
+			}
+			events = append(events, types.Event{Type: eventType, App: app})
+		}
+	}
+
+	// Ignored apps and apps that are never updated get no summary or reminders
+	return rules.FilterEvents(db, autoUpdater.FilterEvents(events))
+}
+
+func getCheckInterval(config types.ServerConfig) time.Duration {
+	if config.Schedule == "" {
+		return time.Duration(config.Interval) * time.Minute
+	}
+
+	// Cron schedules have no fixed interval, the gap between the next two runs is close enough
+	schedule, err := cron.ParseStandard(config.Schedule)
+	if err != nil {
+		return 0
+	}
+
+	next := schedule.Next(time.Now())
+	return schedule.Next(next).Sub(next)
+}
+
+func isLifecycleEvent(eventType string) bool {
+	switch eventType {
+	case types.EventInstalled, types.EventUninstalled, types.EventAppstoreEnabled, types.EventAppstoreDisabled, types.EventAppstoreChanged:
+		return true
+	}
+	return false
+}
+
+func getNotificationTargets(config types.ServerConfig) []types.NotificationConfig {
+	// Without configured targets fall back to the notification URL flag
+	if len(config.Notifications) == 0 {
+		return []types.NotificationConfig{
+			{
+				Name: constants.DefaultNotification,
+				Url:  config.NotificationUrl,
+				Digest: types.NotificationDigest{
+					Enabled: config.Digest,
+					GroupBy: config.DigestGroupBy,
+				},
+			},
+		}
+	}
+
+	return config.Notifications
+}
+
+func getInstances(config types.ServerConfig) []types.InstanceConfig {
+	// Without configured instances fall back to the single instance flags
+	if len(config.Instances) == 0 {
+		return []types.InstanceConfig{
+			{
+				Name:       constants.DefaultInstance,
+				RuntipiUrl: config.RuntipiUrl,
+				JwtSecret:  config.JwtSecret,
+				Insecure:   config.Insecure,
+				ServerName: config.ServerName,
+			},
+		}
+	}
+
+	instances := []types.InstanceConfig{}
+
+	for _, instance := range config.Instances {
+		if instance.ServerName == "" {
+			instance.ServerName = instance.Name
+		}
+		instances = append(instances, instance)
+	}
+
+	return instances
+}
+
 func handleError(err error, msg string) {
 	if err != nil {
 		log.Fatal().Err(err).Msg(msg)
@@ -171,17 +478,36 @@ func init() {
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv()
 
-	serverCmd.Flags().String("notification-url", "", "Notification URL (shoutrrr format)")
-	serverCmd.Flags().String("runtipi-url", "", "Runtipi server URL")
+	serverCmd.Flags().String("notification-url", "", "Notification URL (shoutrrr format, ignored when notifications are set in the config file)")
+	serverCmd.Flags().String("runtipi-url", "", "Runtipi server URL (ignored when instances are set in the config file)")
 	serverCmd.Flags().String("jwt-secret", "", "JWT secret")
 	serverCmd.Flags().String("database-path", "tipimate.db", "Database path")
+	serverCmd.Flags().Int("outbox-max-attempts", 10, "Attempts before a notification is marked as failed (0 retries forever)")
 	serverCmd.Flags().Int("interval", 30, "Refresh interval in minutes")
+	serverCmd.Flags().Int("retry-attempts", 3, "Attempts for each runtipi request before the check fails")
+	serverCmd.Flags().Duration("retry-delay", 10*time.Second, "Delay before retrying a failed runtipi request, doubled on every attempt")
+	serverCmd.Flags().Int("unreachable-threshold", 3, "Failed checks in a row before sending an unreachable alert (0 disables it)")
+	serverCmd.Flags().String("min-age", "", "Minimum age of a version before it is notified or auto updated (e.g. 3d, 12h)")
+	serverCmd.Flags().String("reminder-interval", "", "Send a reminder for updates still pending this long after the last notification (e.g. 7d)")
+	serverCmd.Flags().String("escalate-after", "", "Notify the escalation target about updates still pending this long after the first notification (e.g. 14d)")
+	serverCmd.Flags().String("escalate-to", "", "Notification target used for escalations")
+	serverCmd.Flags().String("schedule", "", "Cron expression for the checks, overrides the interval (e.g. \"0 6,18 * * *\")")
+	serverCmd.Flags().Duration("jitter", 0, "Maximum random delay added before each check (e.g. 5m)")
+	serverCmd.Flags().Bool("run-on-start", true, "Check for updates as soon as the server starts")
 	serverCmd.Flags().String("log-level", "info", "Log level (trace, debug, info, warn, error, fatal, panic)")
 	serverCmd.Flags().Bool("insecure", true, "Disable TLS (https) for services like Gotify, Ntfy etc.")
 	serverCmd.Flags().String("server-name", "", "Server name to use in notifications.")
-
-	// Bind flags to viper
-	viper.BindPFlags(serverCmd.Flags())
+	serverCmd.Flags().Bool("digest", false, "Send one notification per check instead of one per app")
+	serverCmd.Flags().String("digest-group-by", "", "Group digest entries by appstore or server")
+	serverCmd.Flags().String("summary-schedule", "", "Cron expression for the pending updates summary (e.g. \"0 9 * * 1\")")
+	serverCmd.Flags().Bool("notify-applied", false, "Notify when the installed version of an app changes outside of auto updates")
+	serverCmd.Flags().Bool("notify-lifecycle", false, "Notify when apps are installed or uninstalled and when appstores are enabled, disabled or changed")
+	serverCmd.Flags().String("http-address", "", "Address of the HTTP API (e.g. :8080, disabled when empty)")
+	serverCmd.Flags().String("http-token", "", "Bearer token required by the HTTP API")
+	serverCmd.Flags().String("http-username", "", "Basic auth username for the HTTP API and dashboard")
+	serverCmd.Flags().String("http-password", "", "Basic auth password for the HTTP API and dashboard")
+	serverCmd.Flags().String("health-address", "", "Address of the health endpoints without the API (e.g. 127.0.0.1:8081, disabled when empty)")
+	serverCmd.Flags().String("timezone", "", "Timezone used for schedules (defaults to the local timezone)")
 
 	rootCmd.AddCommand(serverCmd)
 }
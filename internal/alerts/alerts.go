@@ -2,65 +2,154 @@ package alerts
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 	"tipimate/internal/constants"
+	"tipimate/internal/metrics"
 	"tipimate/internal/types"
 	"tipimate/internal/utils"
 
 	"github.com/containrrr/shoutrrr"
+	shoutrrrTypes "github.com/containrrr/shoutrrr/pkg/types"
 	"github.com/google/go-querystring/query"
 	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
 )
 
-func NewAlerts(config types.AlertsConfig) *Alerts {
-	return &Alerts{
-		NotificationUrl: config.NotificationUrl,
-		RuntipiUrl:      config.RuntipiUrl,
-		Insecure:        config.Insecure,
-		ServerName:      config.ServerName,
+func NewAlerts(config types.AlertsConfig, db *gorm.DB) (*Alerts, error) {
+	location, err := utils.GetLocation(config.Timezone)
+	if err != nil {
+		return nil, err
 	}
+
+	templates := make(map[string]*messageTemplates)
+
+	for _, target := range config.Targets {
+		targetTemplates, err := parseTemplates(target.Template)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %w", target.Name, err)
+		}
+		templates[target.Name] = targetTemplates
+	}
+
+	return &Alerts{
+		Targets:          config.Targets,
+		Insecure:         config.Insecure,
+		MaxAttempts:      config.MaxAttempts,
+		ReminderInterval: config.ReminderInterval,
+		EscalateAfter:    config.EscalateAfter,
+		EscalateTo:       config.EscalateTo,
+		Database:         db,
+		location:         location,
+		templates:        templates,
+	}, nil
 }
 
 type Alerts struct {
-	NotificationUrl string
-	RuntipiUrl      string
-	Insecure        bool
-	ServerName      string
+	Targets          []types.NotificationConfig
+	Insecure         bool
+	MaxAttempts      int
+	ReminderInterval time.Duration
+	EscalateAfter    time.Duration
+	EscalateTo       string
+	Database         *gorm.DB
+	location         *time.Location
+	templates        map[string]*messageTemplates
+	queueLock        sync.Mutex
+}
+
+func (alerts *Alerts) SendTest(targetName string) (int, error) {
+	errs := []error{}
+	sent := 0
+
+	// Test notifications skip the match rules and the outbox so the result is known right away
+	for _, target := range alerts.Targets {
+		if targetName != "" && target.Name != targetName {
+			continue
+		}
+
+		sent++
+
+		err := alerts.sendTarget(target, &types.Event{Type: types.EventTest})
+		if err != nil {
+			log.Error().Err(err).Str("target", target.Name).Msg("Failed to send test notification")
+			errs = append(errs, fmt.Errorf("target %s: %w", target.Name, err))
+		}
+	}
+
+	if sent == 0 {
+		return 0, fmt.Errorf("unknown target %s", targetName)
+	}
+
+	return sent, errors.Join(errs...)
 }
 
-func (alerts *Alerts) SendAlert(app *types.App, appstores []types.RuntipiAppstore) error {
-	var err error
+func (alerts *Alerts) SendSummary(events []types.Event) error {
+	errs := []error{}
+
+	for _, target := range alerts.Targets {
+		matched := []types.Event{}
 
-	_, slug := utils.SplitURN(app.Urn)
-	appstore := utils.GetAppstore(appstores, slug)
+		for _, event := range events {
+			if matchesTarget(target.Match, &event) {
+				matched = append(matched, event)
+			}
+		}
+
+		if len(matched) == 0 {
+			log.Debug().Str("target", target.Name).Msg("No pending updates for target, skipping summary")
+			continue
+		}
 
-	if appstore == nil {
-		appstore = &types.RuntipiAppstore{
-			Name:    "Unknown Appstore",
-			Slug:    slug,
-			Url:     "",
-			Enabled: true,
+		err := alerts.sendSummary(target, matched)
+		metrics.RecordNotification(getService(target), target.Name, types.EventSummary, err)
+		if err != nil {
+			log.Error().Err(err).Str("target", target.Name).Msg("Failed to send summary")
+			errs = append(errs, fmt.Errorf("target %s: %w", target.Name, err))
 		}
 	}
 
-	service := strings.Split(alerts.NotificationUrl, "://")[0]
+	return errors.Join(errs...)
+}
+
+func (alerts *Alerts) sendTarget(target types.NotificationConfig, event *types.Event) error {
+	service := getService(target)
+
+	title, description, err := alerts.templates[target.Name].render(service, newTemplateData(event))
+	if err != nil {
+		return err
+	}
 
 	switch service {
 	case "discord":
 		log.Debug().Str("service", service).Msg("Selected Discord notification service")
-		err = alerts.sendDiscord(app, *appstore)
+		err = alerts.sendDiscord(target.Url, title, description, event)
 	case "ntfy":
 		log.Debug().Str("service", service).Msg("Selected Ntfy notification service")
-		err = alerts.sendNtfy(app, *appstore)
+		err = alerts.sendNtfy(target.Url, title, description, getEventUrl(event))
 	case "gotify":
 		log.Debug().Str("service", service).Msg("Selected Gotify notification service")
-		err = alerts.sendGotify(app, *appstore)
+		err = alerts.sendGotify(target.Url, title, description)
+	case "telegram":
+		log.Debug().Str("service", service).Msg("Selected Telegram notification service")
+		err = alerts.sendTelegram(target.Url, title, description, alerts.templates[target.Name].isHtml(service, event.Type))
+	case "slack":
+		log.Debug().Str("service", service).Msg("Selected Slack notification service")
+		err = alerts.sendSlack(target.Url, title, description)
+	case "teams":
+		log.Debug().Str("service", service).Msg("Selected Teams notification service")
+		err = alerts.sendTeams(target.Url, title, description)
 	default:
-		log.Warn().Str("service", service).Msg("Unsupported notification service")
+		log.Debug().Str("service", service).Msg("Selected generic notification service")
+		err = alerts.sendGeneric(target.Url, service, title, description)
 	}
 
+	metrics.RecordNotification(service, target.Name, event.Type, err)
+
 	if err != nil {
 		return err
 	}
@@ -68,18 +157,26 @@ func (alerts *Alerts) SendAlert(app *types.App, appstores []types.RuntipiAppstor
 	return nil
 }
 
-func (alerts *Alerts) sendDiscord(app *types.App, appstore types.RuntipiAppstore) error {
-	id, _ := utils.SplitURN(app.Urn)
-	appURL := fmt.Sprintf("%s/apps/%s/%s", alerts.RuntipiUrl, appstore.Slug, id)
-	description := fmt.Sprintf("Your app %s from the %s appstore has an available update!\nUpdate to version %s (%d).", app.Name, appstore.Name, app.DockerVersion, app.Version)
+func getService(target types.NotificationConfig) string {
+	return strings.Split(target.Url, "://")[0]
+}
+
+func (alerts *Alerts) sendDiscord(notificationUrl string, title string, description string, event *types.Event) error {
+	appURL := getEventUrl(event)
 	currentTime := time.Now().Format(time.RFC3339)
 
+	color := "3126084"
+	if event.Type == types.EventUnreachable || event.Type == types.EventFailed || event.Type == types.EventUnhealthy || event.Type == types.EventRolledBack {
+		color = "14034498"
+	}
+
 	var message types.DiscordMessage
 	message.Embeds = []types.DiscordEmbed{
 		{
+			Title:       title,
 			Description: description,
 			Url:         appURL,
-			Color:       "3126084",
+			Color:       color,
 			Timestamp:   currentTime,
 			Footer: types.DiscordEmbedFooter{
 				Text: "Updated at",
@@ -89,12 +186,6 @@ func (alerts *Alerts) sendDiscord(app *types.App, appstore types.RuntipiAppstore
 	message.AvatarUrl = constants.RuntipiLogo
 	message.Username = "Tipimate"
 
-	if alerts.ServerName != "" {
-		message.Embeds[0].Title = fmt.Sprintf("%s - %s (%s)", alerts.ServerName, app.Name, appstore.Name)
-	} else {
-		message.Embeds[0].Title = fmt.Sprintf("%s (%s)", app.Name, appstore.Name)
-	}
-
 	var webhook types.DiscordWebhook
 	webhook.Json = true
 
@@ -103,7 +194,7 @@ func (alerts *Alerts) sendDiscord(app *types.App, appstore types.RuntipiAppstore
 		return err
 	}
 
-	url := fmt.Sprintf("%s?%s", alerts.NotificationUrl, queries.Encode())
+	url := fmt.Sprintf("%s?%s", notificationUrl, queries.Encode())
 
 	messageJson, err := json.Marshal(message)
 	if err != nil {
@@ -118,19 +209,10 @@ func (alerts *Alerts) sendDiscord(app *types.App, appstore types.RuntipiAppstore
 	return nil
 }
 
-func (alerts *Alerts) sendNtfy(app *types.App, appstore types.RuntipiAppstore) error {
-	id, _ := utils.SplitURN(app.Urn)
-	appURL := fmt.Sprintf("%s/apps/%s/%s", alerts.RuntipiUrl, appstore.Slug, id)
-	description := fmt.Sprintf("Your app %s from the %s appstore has an available update!\nUpdate to version %s (%d).", app.Name, appstore.Name, app.DockerVersion, app.Version)
-
+func (alerts *Alerts) sendNtfy(notificationUrl string, title string, description string, clickUrl string) error {
 	var webhook types.NtfyWebhook
-	webhook.Click = appURL
-
-	if alerts.ServerName != "" {
-		webhook.Title = fmt.Sprintf("%s - %s (%s)", alerts.ServerName, app.Name, appstore.Name)
-	} else {
-		webhook.Title = fmt.Sprintf("%s (%s)", app.Name, appstore.Name)
-	}
+	webhook.Click = clickUrl
+	webhook.Title = title
 
 	if alerts.Insecure {
 		webhook.Scheme = "http"
@@ -143,7 +225,7 @@ func (alerts *Alerts) sendNtfy(app *types.App, appstore types.RuntipiAppstore) e
 		return err
 	}
 
-	url := fmt.Sprintf("%s?%s", alerts.NotificationUrl, queries.Encode())
+	url := fmt.Sprintf("%s?%s", notificationUrl, queries.Encode())
 
 	err = shoutrrr.Send(url, description)
 	if err != nil {
@@ -153,26 +235,17 @@ func (alerts *Alerts) sendNtfy(app *types.App, appstore types.RuntipiAppstore) e
 	return nil
 }
 
-func (alerts *Alerts) sendGotify(app *types.App, appstore types.RuntipiAppstore) error {
-	id, _ := utils.SplitURN(app.Urn)
-	appUrl := fmt.Sprintf("%s/apps/%s/%s", alerts.RuntipiUrl, appstore.Slug, id)
-	description := fmt.Sprintf("Your app %s from the %s appstore has an available update!\nUpdate to version %s (%d).\nVisit %s for more information.", app.Name, appstore.Name, app.DockerVersion, app.Version, appUrl)
-
+func (alerts *Alerts) sendGotify(notificationUrl string, title string, description string) error {
 	var webhook types.GotifyWebhook
 	webhook.DisableTls = alerts.Insecure
-
-	if alerts.ServerName != "" {
-		webhook.Title = fmt.Sprintf("%s - %s (%s)", alerts.ServerName, app.Name, appstore.Name)
-	} else {
-		webhook.Title = fmt.Sprintf("%s (%s)", app.Name, appstore.Name)
-	}
+	webhook.Title = title
 
 	queries, err := query.Values(webhook)
 	if err != nil {
 		return err
 	}
 
-	url := fmt.Sprintf("%s?%s", alerts.NotificationUrl, queries.Encode())
+	url := fmt.Sprintf("%s?%s", notificationUrl, queries.Encode())
 
 	err = shoutrrr.Send(url, description)
 	if err != nil {
@@ -181,3 +254,62 @@ func (alerts *Alerts) sendGotify(app *types.App, appstore types.RuntipiAppstore)
 
 	return nil
 }
+
+func (alerts *Alerts) sendTelegram(notificationUrl string, title string, description string, html bool) error {
+	// Without a parse mode shoutrrr escapes the message and sends it as HTML with a bold title
+	parseMode := "None"
+	if html {
+		parseMode = "HTML"
+	}
+
+	params := shoutrrrTypes.Params{
+		"title":     title,
+		"parsemode": parseMode,
+	}
+
+	return sendWithParams(notificationUrl, description, params)
+}
+
+func (alerts *Alerts) sendSlack(notificationUrl string, title string, description string) error {
+	params := shoutrrrTypes.Params{
+		"title": title,
+		"color": "#2fb344",
+	}
+
+	return sendWithParams(notificationUrl, description, params)
+}
+
+func (alerts *Alerts) sendTeams(notificationUrl string, title string, description string) error {
+	params := shoutrrrTypes.Params{
+		"title": title,
+		"color": "2fb344",
+	}
+
+	return sendWithParams(notificationUrl, description, params)
+}
+
+func (alerts *Alerts) sendGeneric(notificationUrl string, service string, title string, description string) error {
+	// Services without a title field get the title as the first line of the message
+	if !slices.Contains(titleServices, service) {
+		return sendWithParams(notificationUrl, fmt.Sprintf("%s\n%s", title, description), shoutrrrTypes.Params{})
+	}
+
+	params := shoutrrrTypes.Params{
+		"title": title,
+	}
+
+	return sendWithParams(notificationUrl, description, params)
+}
+
+// Shoutrrr services that accept a title param
+var titleServices = []string{"bark", "discord", "generic", "gotify", "ifttt", "join", "matrix", "mattermost", "ntfy", "opsgenie", "pushbullet", "pushover", "slack", "smtp", "teams", "telegram", "zulip"}
+
+func sendWithParams(notificationUrl string, message string, params shoutrrrTypes.Params) error {
+	// Params are passed to the sender instead of the URL so existing query parameters are kept
+	sender, err := shoutrrr.CreateSender(notificationUrl)
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(sender.Send(message, &params)...)
+}
@@ -0,0 +1,255 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"tipimate/internal/constants"
+	"tipimate/internal/types"
+	"tipimate/internal/utils"
+
+	"github.com/containrrr/shoutrrr"
+	"github.com/dustin/go-humanize"
+	"github.com/google/go-querystring/query"
+	"github.com/rs/zerolog/log"
+)
+
+// Discord rejects messages with more than 10 embeds
+const discordMaxEmbeds = 10
+
+type digestGroup struct {
+	Name   string
+	Events []types.Event
+}
+
+func (alerts *Alerts) sendDigest(target types.NotificationConfig, events []types.Event) error {
+	service := strings.Split(target.Url, "://")[0]
+	title := getDigestTitle(events)
+	groups := groupEvents(events, target.Digest.GroupBy)
+
+	switch service {
+	case "discord":
+		log.Debug().Str("service", service).Int("events", len(events)).Msg("Sending Discord digest")
+		return alerts.sendDiscordDigest(target, groups)
+	case "ntfy":
+		log.Debug().Str("service", service).Int("events", len(events)).Msg("Sending Ntfy digest")
+		return alerts.sendNtfy(target.Url, title, formatDigest(groups, true), "")
+	case "gotify":
+		log.Debug().Str("service", service).Int("events", len(events)).Msg("Sending Gotify digest")
+		return alerts.sendGotify(target.Url, title, formatDigest(groups, true))
+	default:
+		log.Debug().Str("service", service).Int("events", len(events)).Msg("Sending generic digest")
+		return alerts.sendGeneric(target.Url, service, title, formatDigest(groups, false))
+	}
+}
+
+func (alerts *Alerts) sendDiscordDigest(target types.NotificationConfig, groups []digestGroup) error {
+	currentTime := time.Now().Format(time.RFC3339)
+	embeds := []types.DiscordEmbed{}
+
+	for _, group := range groups {
+		// Grouped digests get one embed per group, otherwise one embed per app
+		if group.Name != "" {
+			embeds = append(embeds, types.DiscordEmbed{
+				Title:       group.Name,
+				Description: formatDigestItems(group.Events, true),
+				Color:       "3126084",
+				Timestamp:   currentTime,
+				Footer: types.DiscordEmbedFooter{
+					Text: "Updated at",
+				},
+			})
+			continue
+		}
+
+		for _, event := range group.Events {
+			title, description, err := alerts.templates[target.Name].render("discord", newTemplateData(&event))
+			if err != nil {
+				return err
+			}
+
+			embeds = append(embeds, types.DiscordEmbed{
+				Title:       title,
+				Description: description,
+				Url:         utils.GetAppUrl(&event.App),
+				Color:       "3126084",
+				Timestamp:   currentTime,
+				Footer: types.DiscordEmbedFooter{
+					Text: "Updated at",
+				},
+			})
+		}
+	}
+
+	return sendDiscordEmbeds(target.Url, embeds)
+}
+
+func (alerts *Alerts) sendSummary(target types.NotificationConfig, events []types.Event) error {
+	service := strings.Split(target.Url, "://")[0]
+	title := getSummaryTitle(events)
+	groups := groupEvents(events, target.Digest.GroupBy)
+
+	switch service {
+	case "discord":
+		log.Debug().Str("service", service).Int("events", len(events)).Msg("Sending Discord summary")
+		embeds := []types.DiscordEmbed{}
+		for _, group := range groups {
+			embedTitle := group.Name
+			if embedTitle == "" {
+				embedTitle = title
+			}
+			embeds = append(embeds, types.DiscordEmbed{
+				Title:       embedTitle,
+				Description: formatDigestItems(group.Events, true),
+				Color:       "3126084",
+				Timestamp:   time.Now().Format(time.RFC3339),
+				Footer: types.DiscordEmbedFooter{
+					Text: "Pending updates as of",
+				},
+			})
+		}
+		return sendDiscordEmbeds(target.Url, embeds)
+	case "ntfy":
+		log.Debug().Str("service", service).Int("events", len(events)).Msg("Sending Ntfy summary")
+		return alerts.sendNtfy(target.Url, title, formatDigest(groups, true), "")
+	case "gotify":
+		log.Debug().Str("service", service).Int("events", len(events)).Msg("Sending Gotify summary")
+		return alerts.sendGotify(target.Url, title, formatDigest(groups, true))
+	default:
+		log.Debug().Str("service", service).Int("events", len(events)).Msg("Sending generic summary")
+		return alerts.sendGeneric(target.Url, service, title, formatDigest(groups, false))
+	}
+}
+
+func sendDiscordEmbeds(notificationUrl string, embeds []types.DiscordEmbed) error {
+	var webhook types.DiscordWebhook
+	webhook.Json = true
+
+	queries, err := query.Values(webhook)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s?%s", notificationUrl, queries.Encode())
+
+	for start := 0; start < len(embeds); start += discordMaxEmbeds {
+		end := min(start+discordMaxEmbeds, len(embeds))
+
+		var message types.DiscordMessage
+		message.Embeds = embeds[start:end]
+		message.AvatarUrl = constants.RuntipiLogo
+		message.Username = "Tipimate"
+
+		messageJson, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+
+		err = shoutrrr.Send(url, string(messageJson))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func groupEvents(events []types.Event, groupBy string) []digestGroup {
+	if groupBy == "" {
+		return []digestGroup{{Events: events}}
+	}
+
+	groups := []digestGroup{}
+	indexes := make(map[string]int)
+
+	for _, event := range events {
+		var name string
+		switch groupBy {
+		case "appstore":
+			name = event.App.Appstore.Name
+		case "server":
+			name = event.App.ServerName
+			if name == "" {
+				name = event.App.Instance
+			}
+		}
+
+		index, ok := indexes[name]
+		if !ok {
+			index = len(groups)
+			indexes[name] = index
+			groups = append(groups, digestGroup{Name: name})
+		}
+
+		groups[index].Events = append(groups[index].Events, event)
+	}
+
+	return groups
+}
+
+func getDigestTitle(events []types.Event) string {
+	serverName := events[0].App.ServerName
+
+	for _, event := range events {
+		if event.App.ServerName != serverName {
+			serverName = ""
+			break
+		}
+	}
+
+	if serverName != "" {
+		return fmt.Sprintf("%s - %d app updates available", serverName, len(events))
+	}
+
+	return fmt.Sprintf("%d app updates available", len(events))
+}
+
+func getSummaryTitle(events []types.Event) string {
+	if len(events) == 1 {
+		return "1 app update pending"
+	}
+	return fmt.Sprintf("%d app updates pending", len(events))
+}
+
+func formatDigest(groups []digestGroup, markdown bool) string {
+	sections := []string{}
+
+	for _, group := range groups {
+		items := formatDigestItems(group.Events, markdown)
+
+		switch {
+		case group.Name == "":
+			sections = append(sections, items)
+		case markdown:
+			sections = append(sections, fmt.Sprintf("### %s\n%s", group.Name, items))
+		default:
+			sections = append(sections, fmt.Sprintf("%s:\n%s", group.Name, items))
+		}
+	}
+
+	return strings.Join(sections, "\n\n")
+}
+
+func formatDigestItems(events []types.Event, markdown bool) string {
+	lines := []string{}
+
+	for _, event := range events {
+		app := &event.App
+
+		var line string
+		if markdown {
+			line = fmt.Sprintf("- [%s](%s) (%s): %s (%d)", app.Name, utils.GetAppUrl(app), app.Appstore.Name, app.DockerVersion, app.LatestVersion)
+		} else {
+			line = fmt.Sprintf("- %s (%s): %s (%d)", app.Name, app.Appstore.Name, app.DockerVersion, app.LatestVersion)
+		}
+
+		if event.Type == types.EventSummary {
+			line += fmt.Sprintf(", pending for %s", humanize.RelTime(app.PendingSince, time.Now(), "", ""))
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,24 @@
+package alerts
+
+import (
+	"slices"
+	"tipimate/internal/matcher"
+	"tipimate/internal/types"
+)
+
+func matchesTarget(match types.NotificationMatch, event *types.Event) bool {
+	// Empty rules match everything
+	if len(match.Events) > 0 && !slices.Contains(match.Events, event.Type) {
+		return false
+	}
+
+	// Server and appstore events are not about an app, so app rules don't filter them
+	if event.App.Urn == "" {
+		if !matcher.MatchesServer(match.Servers, &event.App) {
+			return false
+		}
+		return len(match.Appstores) == 0 || event.App.Appstore.Slug == "" || slices.Contains(match.Appstores, event.App.Appstore.Slug)
+	}
+
+	return matcher.Matches(match.AppMatch, &event.App)
+}
@@ -0,0 +1,350 @@
+package alerts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"tipimate/internal/database"
+	"tipimate/internal/history"
+	"tipimate/internal/metrics"
+	"tipimate/internal/rules"
+	"tipimate/internal/types"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Outbox statuses
+const (
+	OutboxPending = "pending"
+	OutboxFailed  = "failed"
+	OutboxSent    = "sent"
+)
+
+// Retry delays double from the base delay up to the max delay
+const (
+	outboxBaseDelay = 30 * time.Second
+	outboxMaxDelay  = time.Hour
+)
+
+func (alerts *Alerts) QueueAlerts(events []types.Event) error {
+	errs := []error{}
+	now := time.Now()
+
+	for _, event := range events {
+		queued := false
+
+		for _, target := range alerts.Targets {
+			if !matchesTarget(target.Match, &event) {
+				log.Debug().Str("target", target.Name).Str("urn", event.App.Urn).Msg("Event does not match target, skipping")
+				continue
+			}
+
+			queued = true
+
+			err := alerts.queueTarget(target, &event, now)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("target %s: %w", target.Name, err))
+			}
+		}
+
+		// Nothing to deliver, so the app counts as notified right away
+		if !queued {
+			alerts.markNotified(&event)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (alerts *Alerts) queueTarget(target types.NotificationConfig, event *types.Event, now time.Time) error {
+	deliverAt, deferred := alerts.getQuietEnd(target, now)
+	if !deferred {
+		deliverAt = now
+	}
+
+	err := alerts.queueEvent(target, event, deliverAt, deferred)
+	if err != nil {
+		log.Error().Err(err).Str("target", target.Name).Str("urn", event.App.Urn).Msg("Failed to queue alert")
+		return err
+	}
+
+	if deferred {
+		log.Info().Str("target", target.Name).Str("urn", event.App.Urn).Time("deliverAt", deliverAt).Msg("Target is in quiet hours, deferring notification")
+	}
+
+	return nil
+}
+
+func (alerts *Alerts) queueEvent(target types.NotificationConfig, event *types.Event, deliverAt time.Time, deferred bool) error {
+	// The same update is queued on every check until every target got it, targets that already did are skipped
+	query := alerts.Database.Model(&database.Notifications{}).Where("target = ? AND type = ? AND instance = ? AND urn = ? AND latest_version = ?", target.Name, event.Type, event.App.Instance, event.App.Urn, event.App.LatestVersion)
+
+	// Other events share the same key every time they happen (e.g. every outage or reminder), so only one waiting to be sent counts
+	if event.Type != types.EventUpdate {
+		query = query.Where("status = ?", OutboxPending)
+	}
+
+	var existing int64
+	query.Count(&existing)
+
+	if existing != 0 {
+		log.Debug().Str("target", target.Name).Str("urn", event.App.Urn).Msg("Alert already queued, skipping")
+		return nil
+	}
+
+	eventJson, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	res := alerts.Database.Create(&database.Notifications{
+		Target:        target.Name,
+		Type:          event.Type,
+		Instance:      event.App.Instance,
+		Urn:           event.App.Urn,
+		LatestVersion: event.App.LatestVersion,
+		Event:         string(eventJson),
+		Status:        OutboxPending,
+		Deferred:      deferred,
+		DeliverAt:     deliverAt,
+	})
+
+	return res.Error
+}
+
+func (alerts *Alerts) SendQueued() {
+	// The scheduler and the check loop may drain at the same time
+	alerts.queueLock.Lock()
+	defer alerts.queueLock.Unlock()
+
+	now := time.Now()
+
+	for _, target := range alerts.Targets {
+		var notifications []database.Notifications
+
+		res := alerts.Database.Where("target = ? AND status = ? AND deliver_at <= ?", target.Name, OutboxPending, now).Order("id").Find(&notifications)
+		if res.Error != nil {
+			log.Error().Err(res.Error).Str("target", target.Name).Msg("Failed to get queued notifications")
+			continue
+		}
+
+		if len(notifications) == 0 {
+			continue
+		}
+
+		// Back to back windows push the delivery to the end of the next one
+		if quietEnd, quiet := alerts.getQuietEnd(target, now); quiet {
+			alerts.Database.Model(&notifications).Updates(map[string]interface{}{"deliver_at": quietEnd, "deferred": true})
+			continue
+		}
+
+		log.Info().Str("target", target.Name).Int("notifications", len(notifications)).Msg("Sending queued notifications")
+
+		deferred := []database.Notifications{}
+		immediate := []database.Notifications{}
+
+		for _, notification := range notifications {
+			if notification.Deferred {
+				deferred = append(deferred, notification)
+			} else {
+				immediate = append(immediate, notification)
+			}
+		}
+
+		alerts.sendBatch(target, deferred, target.QuietHours.Deliver != "individual")
+		alerts.sendBatch(target, immediate, target.Digest.Enabled)
+	}
+
+	// Targets removed from the config would keep their notifications forever
+	targetNames := []string{}
+	for _, target := range alerts.Targets {
+		targetNames = append(targetNames, target.Name)
+	}
+	alerts.Database.Unscoped().Where("target NOT IN ?", targetNames).Delete(&database.Notifications{})
+
+	// Delivered updates are only needed while their app is waiting for other targets
+	alerts.Database.Unscoped().Where("status = ? AND NOT EXISTS (SELECT 1 FROM apps WHERE apps.instance = notifications.instance AND apps.urn = notifications.urn AND apps.latest_version = notifications.latest_version AND apps.notified = ? AND apps.deleted_at IS NULL)", OutboxSent, false).Delete(&database.Notifications{})
+}
+
+func (alerts *Alerts) sendBatch(target types.NotificationConfig, notifications []database.Notifications, digest bool) {
+	events := []types.Event{}
+	decoded := []database.Notifications{}
+
+	activeRules, err := rules.GetRules(alerts.Database, false)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get ignore rules")
+	}
+
+	for _, notification := range notifications {
+		var event types.Event
+		err := json.Unmarshal([]byte(notification.Event), &event)
+		if err != nil {
+			log.Error().Err(err).Uint("id", notification.ID).Msg("Failed to decode queued notification, marking it as failed")
+			alerts.Database.Model(&notification).Updates(database.Notifications{Status: OutboxFailed, LastError: err.Error()})
+			continue
+		}
+
+		// Deferred and retried updates may have been applied or ignored in the meantime
+		if (event.Type == types.EventUpdate || event.Type == types.EventReminder) && !alerts.isStillPending(&event, activeRules) {
+			log.Info().Str("target", target.Name).Str("urn", event.App.Urn).Msg("Update is no longer pending, dropping notification")
+			removeNotification(alerts.Database, &notification)
+			continue
+		}
+
+		events = append(events, event)
+		decoded = append(decoded, notification)
+	}
+
+	// Only app updates are grouped, server events are always sent on their own
+	digestEvents := []types.Event{}
+	digestNotifications := []database.Notifications{}
+	singleEvents := []types.Event{}
+	singleNotifications := []database.Notifications{}
+
+	for i, event := range events {
+		if digest && event.Type == types.EventUpdate {
+			digestEvents = append(digestEvents, event)
+			digestNotifications = append(digestNotifications, decoded[i])
+		} else {
+			singleEvents = append(singleEvents, event)
+			singleNotifications = append(singleNotifications, decoded[i])
+		}
+	}
+
+	// Digests only make sense with more than one event
+	if len(digestEvents) > 1 {
+		err := alerts.sendDigest(target, digestEvents)
+		metrics.RecordNotification(getService(target), target.Name, "digest", err)
+		if err != nil {
+			log.Error().Err(err).Str("target", target.Name).Msg("Failed to send digest")
+			for i := range digestNotifications {
+				alerts.retryLater(&digestNotifications[i], err)
+			}
+		} else {
+			for i := range digestNotifications {
+				alerts.recordNotified(target, &digestEvents[i])
+				finishNotification(alerts.Database, &digestNotifications[i])
+			}
+		}
+	} else {
+		singleEvents = append(digestEvents, singleEvents...)
+		singleNotifications = append(digestNotifications, singleNotifications...)
+	}
+
+	for i, event := range singleEvents {
+		err := alerts.sendTarget(target, &event)
+		if err != nil {
+			log.Error().Err(err).Str("target", target.Name).Str("urn", event.App.Urn).Msg("Failed to send alert")
+			alerts.retryLater(&singleNotifications[i], err)
+			continue
+		}
+		alerts.recordNotified(target, &event)
+		finishNotification(alerts.Database, &singleNotifications[i])
+	}
+}
+
+func (alerts *Alerts) isStillPending(event *types.Event, activeRules []database.Rules) bool {
+	if rule := rules.Match(activeRules, &event.App); rule != nil {
+		return false
+	}
+
+	var pending int64
+	alerts.Database.Model(&database.Apps{}).Where("instance = ? AND urn = ? AND latest_version = ? AND pending_since IS NOT NULL", event.App.Instance, event.App.Urn, event.App.LatestVersion).Count(&pending)
+	return pending != 0
+}
+
+func (alerts *Alerts) retryLater(notification *database.Notifications, sendErr error) {
+	attempts := notification.Attempts + 1
+
+	if alerts.MaxAttempts > 0 && attempts >= alerts.MaxAttempts {
+		log.Warn().Uint("id", notification.ID).Str("target", notification.Target).Int("attempts", attempts).Msg("Giving up on notification")
+		alerts.Database.Model(notification).Updates(database.Notifications{Status: OutboxFailed, Attempts: attempts, LastError: sendErr.Error()})
+		settleUpdate(alerts.Database, notification)
+		return
+	}
+
+	delay := outboxBaseDelay << (attempts - 1)
+	if delay > outboxMaxDelay || delay <= 0 {
+		delay = outboxMaxDelay
+	}
+
+	alerts.Database.Model(notification).Updates(database.Notifications{Attempts: attempts, LastError: sendErr.Error(), DeliverAt: time.Now().Add(delay)})
+}
+
+func (alerts *Alerts) markNotified(event *types.Event) {
+	markAppNotified(alerts.Database, event.Type, event.App.Instance, event.App.Urn, event.App.LatestVersion)
+}
+
+func (alerts *Alerts) recordNotified(target types.NotificationConfig, event *types.Event) {
+	// Only update notifications and their reminders are part of the update history
+	if event.Type != types.EventUpdate && event.Type != types.EventReminder {
+		return
+	}
+	history.Record(alerts.Database, history.TypeNotified, &event.App, target.Name)
+}
+
+func finishNotification(db *gorm.DB, notification *database.Notifications) {
+	// Delivered updates are kept until the app is notified, so the next check doesn't send them to this target again
+	if notification.Type == types.EventUpdate {
+		db.Model(notification).Updates(database.Notifications{Status: OutboxSent, LastError: ""})
+	} else {
+		db.Unscoped().Delete(notification)
+	}
+
+	settleUpdate(db, notification)
+}
+
+func removeNotification(db *gorm.DB, notification *database.Notifications) {
+	db.Unscoped().Delete(notification)
+	settleUpdate(db, notification)
+}
+
+func settleUpdate(db *gorm.DB, notification *database.Notifications) {
+	// Apps are notified once no target is still waiting for the update, failed targets can be retried from the outbox
+	var remaining int64
+	db.Model(&database.Notifications{}).Where("type = ? AND instance = ? AND urn = ? AND latest_version = ? AND status = ?", notification.Type, notification.Instance, notification.Urn, notification.LatestVersion, OutboxPending).Count(&remaining)
+
+	if remaining == 0 {
+		markAppNotified(db, notification.Type, notification.Instance, notification.Urn, notification.LatestVersion)
+	}
+}
+
+func markAppNotified(db *gorm.DB, eventType string, instance string, urn string, latestVersion int) {
+	if eventType != types.EventUpdate {
+		return
+	}
+
+	now := time.Now()
+	db.Model(&database.Apps{}).Where("instance = ? AND urn = ? AND latest_version = ?", instance, urn, latestVersion).Updates(database.Apps{Notified: true, NotifiedAt: &now})
+}
+
+func RetryNotifications(db *gorm.DB, ids []uint) (int64, error) {
+	query := db.Model(&database.Notifications{})
+
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	} else {
+		query = query.Where("status = ?", OutboxFailed)
+	}
+
+	res := query.Updates(map[string]interface{}{"status": OutboxPending, "attempts": 0, "deliver_at": time.Now()})
+	return res.RowsAffected, res.Error
+}
+
+func DeleteNotifications(db *gorm.DB, ids []uint) (int64, error) {
+	var notifications []database.Notifications
+
+	res := db.Where("id IN ?", ids).Find(&notifications)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+
+	// Dropped updates are not queued again by the next check
+	for i := range notifications {
+		removeNotification(db, &notifications[i])
+	}
+
+	return int64(len(notifications)), nil
+}
@@ -0,0 +1,23 @@
+package alerts
+
+import (
+	"time"
+	"tipimate/internal/types"
+	"tipimate/internal/utils"
+)
+
+func ValidateQuietHours(config types.QuietHoursConfig) error {
+	_, err := utils.GetLocation(config.Timezone)
+	return err
+}
+
+func (alerts *Alerts) getQuietEnd(target types.NotificationConfig, now time.Time) (time.Time, bool) {
+	location := alerts.location
+
+	if target.QuietHours.Timezone != "" {
+		// Already validated on startup
+		location, _ = utils.GetLocation(target.QuietHours.Timezone)
+	}
+
+	return utils.GetWindowsEnd(target.QuietHours.Windows, now.In(location))
+}
@@ -0,0 +1,108 @@
+package alerts
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"tipimate/internal/database"
+	"tipimate/internal/types"
+
+	"github.com/rs/zerolog/log"
+)
+
+func ValidateReminders(config types.AlertsConfig) error {
+	if config.EscalateTo == "" {
+		return nil
+	}
+
+	for _, target := range config.Targets {
+		if target.Name == config.EscalateTo {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown escalation target %s", config.EscalateTo)
+}
+
+func (alerts *Alerts) QueueReminders(events []types.Event) error {
+	if alerts.ReminderInterval == 0 && alerts.EscalateAfter == 0 {
+		return nil
+	}
+
+	errs := []error{}
+	now := time.Now()
+
+	for _, event := range events {
+		var dbApp database.Apps
+
+		// Only updates that were delivered at least once get reminders
+		res := alerts.Database.Where("instance = ? AND urn = ? AND latest_version = ? AND notified = ?", event.App.Instance, event.App.Urn, event.App.LatestVersion, true).Limit(1).Find(&dbApp)
+		if res.Error != nil {
+			errs = append(errs, res.Error)
+			continue
+		}
+
+		if res.RowsAffected == 0 || dbApp.NotifiedAt == nil {
+			continue
+		}
+
+		reminder := types.Event{Type: types.EventReminder, App: event.App}
+
+		lastSent := *dbApp.NotifiedAt
+		if dbApp.RemindedAt != nil && dbApp.RemindedAt.After(lastSent) {
+			lastSent = *dbApp.RemindedAt
+		}
+
+		if alerts.ReminderInterval > 0 && now.Sub(lastSent) >= alerts.ReminderInterval {
+			log.Info().Str("instance", event.App.Instance).Str("urn", event.App.Urn).Msg("Update is still pending, queueing reminder")
+
+			err := alerts.queueMatching(&reminder, now)
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				alerts.Database.Model(&dbApp).Update("reminded_at", now)
+			}
+		}
+
+		if alerts.EscalateAfter > 0 && dbApp.EscalatedAt == nil && now.Sub(*dbApp.NotifiedAt) >= alerts.EscalateAfter {
+			log.Info().Str("instance", event.App.Instance).Str("urn", event.App.Urn).Str("target", alerts.EscalateTo).Msg("Update is still pending, escalating")
+
+			err := alerts.queueEscalation(&reminder, now)
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				alerts.Database.Model(&dbApp).Update("escalated_at", now)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (alerts *Alerts) queueMatching(event *types.Event, now time.Time) error {
+	errs := []error{}
+
+	for _, target := range alerts.Targets {
+		if !matchesTarget(target.Match, event) {
+			continue
+		}
+
+		err := alerts.queueTarget(target, event, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("target %s: %w", target.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (alerts *Alerts) queueEscalation(event *types.Event, now time.Time) error {
+	// Escalations skip the match rules, the target was picked explicitly
+	for _, target := range alerts.Targets {
+		if target.Name == alerts.EscalateTo {
+			return alerts.queueTarget(target, event, now)
+		}
+	}
+
+	return fmt.Errorf("unknown escalation target %s", alerts.EscalateTo)
+}
@@ -0,0 +1,276 @@
+package alerts
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+	"tipimate/internal/types"
+	"tipimate/internal/utils"
+
+	"github.com/dustin/go-humanize"
+)
+
+var defaultTitleTemplate = `{{ if eq .Event "reminder" }}Reminder: {{ end }}{{ if .ServerName }}{{ .ServerName }} - {{ end }}{{ .Name }} ({{ .Appstore }})`
+
+var defaultBodyTemplate = "Your app {{ .Name }} from the {{ .Appstore }} appstore has an available update!\nUpdate to version {{ .DockerVersion }} ({{ .LatestVersion }}).\nVisit {{ .AppUrl }} for more information."
+
+var defaultBodyTemplates = map[string]string{
+	"discord":  "Your app {{ .Name }} from the {{ .Appstore }} appstore has an available update!\nUpdate to version {{ .DockerVersion }} ({{ .LatestVersion }}).",
+	"ntfy":     "Your app {{ .Name }} from the {{ .Appstore }} appstore has an available update!\nUpdate to version {{ .DockerVersion }} ({{ .LatestVersion }}).",
+	"telegram": "Your app <b>{{ html .Name }}</b> from the {{ html .Appstore }} appstore has an available update!\nUpdate to version <code>{{ html .DockerVersion }}</code> ({{ .LatestVersion }}).\n<a href=\"{{ html .AppUrl }}\">Open in runtipi</a>",
+	"slack":    "Your app *{{ .Name }}* from the {{ .Appstore }} appstore has an available update!\nUpdate to version `{{ .DockerVersion }}` ({{ .LatestVersion }}).\n<{{ .AppUrl }}|Open in runtipi>",
+	"teams":    "Your app **{{ .Name }}** from the {{ .Appstore }} appstore has an available update!\n\nUpdate to version {{ .DockerVersion }} ({{ .LatestVersion }}).\n\n[Open in runtipi]({{ .AppUrl }})",
+}
+
+// Custom templates are written for update notifications, other events use built-in messages
+var eventTitleTemplates = map[string]string{
+	types.EventUnreachable:      `{{ if .ServerName }}{{ .ServerName }}{{ else }}{{ .Instance }}{{ end }} is unreachable`,
+	types.EventRecovered:        `{{ if .ServerName }}{{ .ServerName }}{{ else }}{{ .Instance }}{{ end }} is reachable again`,
+	types.EventUpdated:          `{{ if .ServerName }}{{ .ServerName }} - {{ end }}{{ .Name }} updated`,
+	types.EventFailed:           `{{ if .ServerName }}{{ .ServerName }} - {{ end }}{{ .Name }} update failed`,
+	types.EventVerified:         `{{ if .ServerName }}{{ .ServerName }} - {{ end }}{{ .Name }} update succeeded`,
+	types.EventUnhealthy:        `{{ if .ServerName }}{{ .ServerName }} - {{ end }}{{ .Name }} failed to come back after update`,
+	types.EventRolledBack:       `{{ if .ServerName }}{{ .ServerName }} - {{ end }}{{ .Name }} update rolled back`,
+	types.EventApplied:          `{{ if .ServerName }}{{ .ServerName }} - {{ end }}{{ .Name }} updated`,
+	types.EventInstalled:        `{{ if .ServerName }}{{ .ServerName }} - {{ end }}{{ .Name }} installed`,
+	types.EventUninstalled:      `{{ if .ServerName }}{{ .ServerName }} - {{ end }}{{ .Name }} uninstalled`,
+	types.EventAppstoreEnabled:  `{{ if .ServerName }}{{ .ServerName }} - {{ end }}Appstore {{ .Appstore }} enabled`,
+	types.EventAppstoreDisabled: `{{ if .ServerName }}{{ .ServerName }} - {{ end }}Appstore {{ .Appstore }} disabled`,
+	types.EventAppstoreChanged:  `{{ if .ServerName }}{{ .ServerName }} - {{ end }}Appstore {{ .Appstore }} changed`,
+	types.EventTest:             `{{ if .ServerName }}{{ .ServerName }} - {{ end }}Tipimate test notification`,
+}
+
+var eventBodyTemplates = map[string]string{
+	types.EventUnreachable:      "Tipimate could not reach your runtipi server at {{ .RuntipiUrl }}.\nError: {{ .Message }}",
+	types.EventRecovered:        "Your runtipi server at {{ .RuntipiUrl }} is reachable again.",
+	types.EventUpdated:          "Your app {{ .Name }} from the {{ .Appstore }} appstore was updated to version {{ .DockerVersion }} ({{ .LatestVersion }}).",
+	types.EventFailed:           "Tipimate could not update your app {{ .Name }} from the {{ .Appstore }} appstore to version {{ .DockerVersion }} ({{ .LatestVersion }}).\nError: {{ .Message }}",
+	types.EventVerified:         "Your app {{ .Name }} from the {{ .Appstore }} appstore was updated to version {{ .DockerVersion }} ({{ .LatestVersion }}) and is running.",
+	types.EventUnhealthy:        "Your app {{ .Name }} from the {{ .Appstore }} appstore did not come back after the update to version {{ .DockerVersion }} ({{ .LatestVersion }}).\nError: {{ .Message }}",
+	types.EventRolledBack:       "Your app {{ .Name }} from the {{ .Appstore }} appstore did not come back after the update to version {{ .DockerVersion }} ({{ .LatestVersion }}) and was restored to version {{ .Version }}.\nDetails: {{ .Message }}",
+	types.EventApplied:          "Your app {{ .Name }} from the {{ .Appstore }} appstore was updated from tipi version {{ .PreviousVersion }} to {{ .Version }}{{ if .CurrentDockerVersion }} (docker version {{ .CurrentDockerVersion }}){{ end }}.",
+	types.EventInstalled:        "The app {{ .Name }} from the {{ .Appstore }} appstore was installed{{ if .CurrentDockerVersion }} with version {{ .CurrentDockerVersion }}{{ end }}.",
+	types.EventUninstalled:      "The app {{ .Name }} from the {{ .Appstore }} appstore was uninstalled.",
+	types.EventAppstoreEnabled:  "The {{ .Appstore }} appstore ({{ .AppstoreSlug }}) was enabled or added.",
+	types.EventAppstoreDisabled: "The {{ .Appstore }} appstore ({{ .AppstoreSlug }}) was disabled or removed.",
+	types.EventAppstoreChanged:  "The {{ .Appstore }} appstore ({{ .AppstoreSlug }}) was changed.\n{{ .Message }}",
+	types.EventTest:             "This is a test notification from tipimate, notifications to this target are working.",
+}
+
+var templateFuncs = template.FuncMap{
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"trim":    strings.TrimSpace,
+	"replace": strings.ReplaceAll,
+	"default": func(fallback string, value string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	},
+	"truncate": func(length int, value string) string {
+		if len(value) <= length {
+			return value
+		}
+		return value[:length] + "…"
+	},
+	"now": func(layout string) string {
+		return time.Now().Format(layout)
+	},
+}
+
+// Sample data used to catch template errors at startup
+var sampleTemplateData = types.TemplateData{
+	Event:                types.EventUpdate,
+	Name:                 "Nextcloud",
+	Urn:                  "nextcloud:official",
+	Appstore:             "Official",
+	AppstoreSlug:         "official",
+	Version:              1,
+	LatestVersion:        2,
+	DockerVersion:        "1.1.0",
+	CurrentDockerVersion: "1.0.0",
+	UpdateClass:          "minor",
+	ServerName:           "Tipimate",
+	Instance:             "default",
+	RuntipiUrl:           "https://localhost",
+	AppUrl:               "https://localhost/apps/official/nextcloud",
+	PendingFor:           "3 days",
+}
+
+type messageTemplates struct {
+	Title *template.Template
+	Body  *template.Template
+}
+
+func parseTemplates(config types.NotificationTemplate) (*messageTemplates, error) {
+	titleSource, err := readTemplate(config.Title, config.TitleFile)
+	if err != nil {
+		return nil, err
+	}
+
+	bodySource, err := readTemplate(config.Body, config.BodyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if titleSource == "" {
+		titleSource = defaultTitleTemplate
+	}
+
+	title, err := template.New("title").Funcs(templateFuncs).Parse(titleSource)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := &messageTemplates{
+		Title: title,
+	}
+
+	// Without a custom body every service uses its own default
+	if bodySource != "" {
+		templates.Body, err = template.New("body").Funcs(templateFuncs).Parse(bodySource)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return templates, nil
+}
+
+func readTemplate(inline string, file string) (string, error) {
+	if file == "" {
+		return inline, nil
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+func ValidateTemplate(config types.NotificationTemplate) error {
+	templates, err := parseTemplates(config)
+	if err != nil {
+		return err
+	}
+
+	_, err = executeTemplate(templates.Title, sampleTemplateData)
+	if err != nil {
+		return err
+	}
+
+	if templates.Body != nil {
+		_, err = executeTemplate(templates.Body, sampleTemplateData)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (templates *messageTemplates) render(service string, data types.TemplateData) (string, string, error) {
+	if _, ok := eventTitleTemplates[data.Event]; ok {
+		return renderBuiltinEvent(data)
+	}
+
+	title, err := executeTemplate(templates.Title, data)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to render title: %w", err)
+	}
+
+	body := templates.Body
+	if body == nil {
+		body = getDefaultBodyTemplate(service)
+	}
+
+	description, err := executeTemplate(body, data)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to render body: %w", err)
+	}
+
+	return title, description, nil
+}
+
+// Only the built-in Telegram update body escapes its fields, everything else is plain text
+func (templates *messageTemplates) isHtml(service string, eventType string) bool {
+	_, builtin := eventTitleTemplates[eventType]
+	return service == "telegram" && templates.Body == nil && !builtin
+}
+
+func renderBuiltinEvent(data types.TemplateData) (string, string, error) {
+	title, err := executeTemplate(template.Must(template.New("title").Parse(eventTitleTemplates[data.Event])), data)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to render title: %w", err)
+	}
+
+	description, err := executeTemplate(template.Must(template.New("body").Parse(eventBodyTemplates[data.Event])), data)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to render body: %w", err)
+	}
+
+	return title, description, nil
+}
+
+func getDefaultBodyTemplate(service string) *template.Template {
+	source, ok := defaultBodyTemplates[service]
+	if !ok {
+		source = defaultBodyTemplate
+	}
+	return template.Must(template.New(service).Funcs(templateFuncs).Parse(source))
+}
+
+func executeTemplate(tmpl *template.Template, data types.TemplateData) (string, error) {
+	var buf bytes.Buffer
+
+	err := tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func newTemplateData(event *types.Event) types.TemplateData {
+	app := &event.App
+	return types.TemplateData{
+		Event:                event.Type,
+		Name:                 app.Name,
+		Urn:                  app.Urn,
+		Appstore:             app.Appstore.Name,
+		AppstoreSlug:         app.Appstore.Slug,
+		Version:              app.Version,
+		PreviousVersion:      app.PreviousVersion,
+		LatestVersion:        app.LatestVersion,
+		DockerVersion:        app.DockerVersion,
+		CurrentDockerVersion: app.CurrentDockerVersion,
+		UpdateClass:          app.UpdateClass,
+		ServerName:           app.ServerName,
+		Instance:             app.Instance,
+		RuntipiUrl:           app.RuntipiUrl,
+		AppUrl:               getEventUrl(event),
+		Message:              event.Message,
+		PendingFor:           getPendingFor(app),
+	}
+}
+
+func getPendingFor(app *types.App) string {
+	if app.PendingSince.IsZero() {
+		return ""
+	}
+	return humanize.RelTime(app.PendingSince, time.Now(), "", "")
+}
+
+func getEventUrl(event *types.Event) string {
+	// Server events link to the dashboard instead of an app
+	if event.App.Urn == "" {
+		return event.App.RuntipiUrl
+	}
+	return utils.GetAppUrl(&event.App)
+}
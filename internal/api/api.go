@@ -1,15 +1,23 @@
 package api
 
 import (
+	"bytes"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"time"
+	"tipimate/internal/metrics"
 	"tipimate/internal/types"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// A runtipi server that accepts the connection but never answers must not block the checks
+const requestTimeout = 30 * time.Second
+
 func NewAPI(config types.APIConfig) (*API, error) {
 	token, err := createJWT(config.Secret)
 
@@ -23,6 +31,7 @@ func NewAPI(config types.APIConfig) (*API, error) {
 
 	client := http.Client{
 		Transport: tr,
+		Timeout:   requestTimeout,
 	}
 
 	return &API{
@@ -51,23 +60,42 @@ func createJWT(secret string) (string, error) {
 	return signed, err
 }
 
-func (api *API) apiRequest(path string, method string) (*http.Response, error) {
+func (api *API) apiRequest(path string, method string, body interface{}) (*http.Response, error) {
 	url := fmt.Sprintf("%s%s", api.RuntipiUrl, path)
 	bearer := fmt.Sprintf("Bearer %s", api.Token)
 
-	req, err := http.NewRequest(method, url, nil)
+	var reader io.Reader
+	if body != nil {
+		bodyJson, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(bodyJson)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("Authorization", bearer)
 
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	started := time.Now()
+
 	res, err := api.Client.Do(req)
 	if err != nil {
+		metrics.RecordApiRequest(api.RuntipiUrl, method, time.Since(started), 0)
 		return nil, err
 	}
 
+	metrics.RecordApiRequest(api.RuntipiUrl, method, time.Since(started), res.StatusCode)
+
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		res.Body.Close()
 		return nil, fmt.Errorf("API request failed with status code: %d", res.StatusCode)
 	}
 
@@ -77,7 +105,7 @@ func (api *API) apiRequest(path string, method string) (*http.Response, error) {
 func (api *API) GetInstalledApps() (types.GetInstalledAppsResponse, error) {
 	var installedApps types.GetInstalledAppsResponse
 
-	res, err := api.apiRequest("/api/apps/installed", "GET")
+	res, err := api.apiRequest("/api/apps/installed", "GET", nil)
 
 	if err != nil {
 		return installedApps, err
@@ -96,7 +124,7 @@ func (api *API) GetInstalledApps() (types.GetInstalledAppsResponse, error) {
 func (api *API) GetAppstores() (types.GetAppstoresResponse, error) {
 	var appstores types.GetAppstoresResponse
 
-	res, err := api.apiRequest("/api/marketplace/enabled", "GET")
+	res, err := api.apiRequest("/api/marketplace/enabled", "GET", nil)
 
 	if err != nil {
 		return appstores, err
@@ -111,3 +139,66 @@ func (api *API) GetAppstores() (types.GetAppstoresResponse, error) {
 
 	return appstores, nil
 }
+
+func (api *API) UpdateApp(urn string) error {
+	body := types.UpdateAppBody{
+		PerformBackup: false,
+	}
+
+	res, err := api.apiRequest(fmt.Sprintf("/api/app-lifecycle/%s/update", url.PathEscape(urn)), "POST", body)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	return nil
+}
+
+func (api *API) BackupApp(urn string) error {
+	res, err := api.apiRequest(fmt.Sprintf("/api/backups/%s/backup", url.PathEscape(urn)), "POST", nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	return nil
+}
+
+func (api *API) GetAppBackups(urn string) (types.GetAppBackupsResponse, error) {
+	var backups types.GetAppBackupsResponse
+
+	res, err := api.apiRequest(fmt.Sprintf("/api/backups/%s?page=0&pageSize=100", url.PathEscape(urn)), "GET", nil)
+
+	if err != nil {
+		return backups, err
+	}
+
+	defer res.Body.Close()
+
+	err = json.NewDecoder(res.Body).Decode(&backups)
+	if err != nil {
+		return backups, err
+	}
+
+	return backups, nil
+}
+
+func (api *API) RestoreAppBackup(urn string, filename string) error {
+	body := types.RestoreAppBackupBody{
+		Filename: filename,
+	}
+
+	res, err := api.apiRequest(fmt.Sprintf("/api/backups/%s/restore", url.PathEscape(urn)), "POST", body)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	return nil
+}
@@ -3,3 +3,7 @@ package constants
 var RuntipiLogo = "https://github.com/runtipi/.github/blob/main/branding/tipi-transparent-small-offset.png?raw=true"
 
 var Version = "development"
+
+var DefaultInstance = "default"
+
+var DefaultNotification = "default"
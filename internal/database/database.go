@@ -1,6 +1,10 @@
 package database
 
 import (
+	"errors"
+	"time"
+	"tipimate/internal/constants"
+
 	"github.com/glebarez/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -8,9 +12,91 @@ import (
 
 type Apps struct {
 	gorm.Model
+	Instance             string
+	Urn                  string
+	Name                 string
+	Appstore             string
+	Version              int
+	LatestVersion        int
+	DockerVersion        string
+	CurrentDockerVersion string
+	PendingSince         *time.Time
+	LatestSeenAt         *time.Time
+	Notified             bool
+	NotifiedAt           *time.Time
+	RemindedAt           *time.Time
+	EscalatedAt          *time.Time
+}
+
+type Notifications struct {
+	gorm.Model
+	Target        string
+	Type          string
+	Instance      string
 	Urn           string
+	LatestVersion int
+	Event         string
+	Status        string
+	Deferred      bool
+	Attempts      int
+	LastError     string
+	DeliverAt     time.Time
+}
+
+type Updates struct {
+	gorm.Model
+	Instance      string
+	Urn           string
+	Name          string
+	Appstore      string
 	Version       int
 	LatestVersion int
+	DockerVersion string
+	Status        string
+	Step          string
+	Backup        string
+	Error         string
+	FinishedAt    *time.Time
+}
+
+type Rules struct {
+	gorm.Model
+	Urn      string
+	Appstore string
+	Server   string
+	Version  string
+	Until    *time.Time
+	Reason   string
+}
+
+type History struct {
+	gorm.Model
+	Type            string
+	Instance        string
+	ServerName      string
+	Urn             string
+	Name            string
+	Appstore        string
+	Version         int
+	PreviousVersion int
+	LatestVersion   int
+	DockerVersion   string
+	Target          string
+}
+
+type Appstores struct {
+	gorm.Model
+	Instance string
+	Slug     string
+	Name     string
+	Url      string
+	Enabled  bool
+}
+
+type Instances struct {
+	gorm.Model
+	Name      string
+	CheckedAt *time.Time
 }
 
 type AppsOld struct {
@@ -20,8 +106,26 @@ type AppsOld struct {
 	LatestVersion int
 }
 
-func InitDatabase(path string) (*gorm.DB, error) {
-	// Open db
+var errRollback = errors.New("rollback")
+
+func CheckWritable(db *gorm.DB) error {
+	// The write is rolled back, it only proves that the database file and its journal can be written
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Create(&Instances{Name: "writable-check"})
+		if res.Error != nil {
+			return res.Error
+		}
+		return errRollback
+	})
+
+	if errors.Is(err, errRollback) {
+		return nil
+	}
+	return err
+}
+
+// Opens the database without migrating it, for commands that only read what the server wrote
+func OpenDatabase(path string) (*gorm.DB, error) {
 	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
 	})
@@ -30,6 +134,24 @@ func InitDatabase(path string) (*gorm.DB, error) {
 		return nil, err
 	}
 
+	// Serialize access since instances are checked concurrently
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+func InitDatabase(path string) (*gorm.DB, error) {
+	// Open db
+	db, err := OpenDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+
 	// Rename old table if it exists
 	if db.Migrator().HasTable("schemas") {
 		err = db.Migrator().RenameTable("schemas", "apps_old")
@@ -38,13 +160,29 @@ func InitDatabase(path string) (*gorm.DB, error) {
 		}
 	}
 
+	// Apps from before the outbox have already been notified
+	notifiedExists := !db.Migrator().HasTable(&Apps{}) || db.Migrator().HasColumn(&Apps{}, "Notified")
+
 	// Migrate db
-	err = db.AutoMigrate(&Apps{})
+	err = db.AutoMigrate(&Apps{}, &Notifications{}, &Updates{}, &Rules{}, &History{}, &Appstores{}, &Instances{})
 
 	if err != nil {
 		return nil, err
 	}
 
+	if !notifiedExists {
+		res := db.Model(&Apps{}).Unscoped().Where("1 = 1").Update("notified", true)
+		if res.Error != nil {
+			return nil, res.Error
+		}
+	}
+
+	// Queued notifications from before the outbox are pending deliveries
+	res := db.Model(&Notifications{}).Unscoped().Where("status IS NULL OR status = ?", "").Update("status", "pending")
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
 	// Migrate old data
 	var oldRecords []AppsOld
 	db.Table("apps_old").Find(&oldRecords)
@@ -52,9 +190,11 @@ func InitDatabase(path string) (*gorm.DB, error) {
 	for _, record := range oldRecords {
 		// Save new record
 		res := db.Create(&Apps{
+			Instance:      constants.DefaultInstance,
 			Urn:           record.Id + ":migrated",
 			Version:       record.Version,
 			LatestVersion: record.LatestVersion,
+			Notified:      true,
 		})
 		if res.Error != nil {
 			return nil, res.Error
@@ -69,6 +209,18 @@ func InitDatabase(path string) (*gorm.DB, error) {
 		}
 	}
 
+	// Assign apps from before multi instance support to the default instance
+	res = db.Model(&Apps{}).Unscoped().Where("instance IS NULL OR instance = ?", "").Update("instance", constants.DefaultInstance)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	// Versions seen before the minimum age existed count from when they became pending
+	res = db.Model(&Apps{}).Unscoped().Where("latest_seen_at IS NULL").Update("latest_seen_at", gorm.Expr("COALESCE(pending_since, created_at)"))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
 	// Return db
 	return db, nil
 }
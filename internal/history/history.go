@@ -0,0 +1,82 @@
+package history
+
+import (
+	"time"
+	"tipimate/internal/database"
+	"tipimate/internal/types"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// History entry types
+const (
+	TypeDetected = "detected"
+	TypeNotified = "notified"
+	TypeApplied  = "applied"
+)
+
+type Filter struct {
+	Urn    string
+	Server string
+	Types  []string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+func Record(db *gorm.DB, entryType string, app *types.App, target string) {
+	entry := database.History{
+		Type:            entryType,
+		Instance:        app.Instance,
+		ServerName:      app.ServerName,
+		Urn:             app.Urn,
+		Name:            app.Name,
+		Appstore:        app.Appstore.Name,
+		Version:         app.Version,
+		PreviousVersion: app.PreviousVersion,
+		LatestVersion:   app.LatestVersion,
+		DockerVersion:   app.DockerVersion,
+		Target:          target,
+	}
+
+	// History is best effort, a failed insert never stops a check or a notification
+	res := db.Create(&entry)
+	if res.Error != nil {
+		log.Error().Err(res.Error).Str("type", entryType).Str("instance", app.Instance).Str("urn", app.Urn).Msg("Failed to record history")
+	}
+}
+
+func GetHistory(db *gorm.DB, filter Filter) ([]database.History, error) {
+	var entries []database.History
+
+	query := db.Order("id DESC")
+
+	// Sqlite globs use the same syntax as the ignore rules
+	if filter.Urn != "" {
+		query = query.Where("urn GLOB ?", filter.Urn)
+	}
+
+	if filter.Server != "" {
+		query = query.Where("instance = ? OR server_name = ?", filter.Server, filter.Server)
+	}
+
+	if len(filter.Types) > 0 {
+		query = query.Where("type IN ?", filter.Types)
+	}
+
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at <= ?", filter.Until)
+	}
+
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	res := query.Find(&entries)
+	return entries, res.Error
+}
@@ -0,0 +1,58 @@
+package matcher
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"tipimate/internal/types"
+)
+
+func Matches(match types.AppMatch, app *types.App) bool {
+	// Empty rules match everything
+	if !MatchesServer(match.Servers, app) {
+		return false
+	}
+
+	if len(match.Appstores) > 0 && !slices.Contains(match.Appstores, app.Appstore.Slug) {
+		return false
+	}
+
+	if len(match.Urns) > 0 && !slices.ContainsFunc(match.Urns, func(pattern string) bool { return MatchesGlob(pattern, app.Urn) }) {
+		return false
+	}
+
+	if len(match.Classes) > 0 && !slices.Contains(match.Classes, app.UpdateClass) {
+		return false
+	}
+
+	return true
+}
+
+// Servers are matched by instance name or by server name
+func MatchesServer(servers []string, app *types.App) bool {
+	return len(servers) == 0 || slices.Contains(servers, app.Instance) || slices.Contains(servers, app.ServerName)
+}
+
+func MatchesGlob(pattern string, value string) bool {
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
+
+func Validate(match types.AppMatch) error {
+	for _, pattern := range match.Urns {
+		err := ValidateGlob(pattern)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ValidateGlob(pattern string) error {
+	// Catch malformed globs before they silently never match
+	_, err := path.Match(pattern, "")
+	if err != nil {
+		return fmt.Errorf("invalid urn pattern %q: %w", pattern, err)
+	}
+	return nil
+}
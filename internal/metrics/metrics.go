@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+	"tipimate/internal/types"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Check results
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+var pendingUpdates = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "tipimate_pending_updates",
+	Help: "Pending updates, one series per app with an update",
+}, []string{"instance", "server_name", "appstore", "urn", "class"})
+
+var checkDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "tipimate_check_duration_seconds",
+	Help:    "Duration of the update checks per server",
+	Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+}, []string{"instance"})
+
+var checks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "tipimate_checks_total",
+	Help: "Update checks per server and result",
+}, []string{"instance", "result"})
+
+var lastSuccessfulCheck = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "tipimate_last_successful_check_timestamp_seconds",
+	Help: "Unix time of the last successful check per server",
+}, []string{"instance"})
+
+var apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "tipimate_runtipi_request_duration_seconds",
+	Help:    "Latency of the runtipi API requests",
+	Buckets: prometheus.DefBuckets,
+}, []string{"runtipi_url", "method"})
+
+var apiRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "tipimate_runtipi_requests_total",
+	Help: "Runtipi API requests by status code, failed connections use the error code",
+}, []string{"runtipi_url", "method", "code"})
+
+var notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "tipimate_notifications_total",
+	Help: "Notifications sent per service, target, kind and result",
+}, []string{"service", "target", "kind", "result"})
+
+func RecordCheck(instance string, duration time.Duration, err error) {
+	checkDuration.WithLabelValues(instance).Observe(duration.Seconds())
+
+	if err != nil {
+		checks.WithLabelValues(instance, ResultFailure).Inc()
+		return
+	}
+
+	checks.WithLabelValues(instance, ResultSuccess).Inc()
+	lastSuccessfulCheck.WithLabelValues(instance).SetToCurrentTime()
+}
+
+func SetPendingUpdates(instance string, apps []types.App) {
+	// Apps that were updated must disappear from the gauge
+	pendingUpdates.DeletePartialMatch(prometheus.Labels{"instance": instance})
+
+	for _, app := range apps {
+		pendingUpdates.WithLabelValues(instance, app.ServerName, app.Appstore.Slug, app.Urn, app.UpdateClass).Set(1)
+	}
+}
+
+func RecordApiRequest(runtipiUrl string, method string, duration time.Duration, statusCode int) {
+	code := "error"
+	if statusCode != 0 {
+		code = strconv.Itoa(statusCode)
+	}
+
+	apiRequestDuration.WithLabelValues(runtipiUrl, method).Observe(duration.Seconds())
+	apiRequests.WithLabelValues(runtipiUrl, method, code).Inc()
+}
+
+func RecordNotification(service string, target string, kind string, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultFailure
+	}
+
+	notifications.WithLabelValues(service, target, kind, result).Inc()
+}
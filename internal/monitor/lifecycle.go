@@ -0,0 +1,138 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"tipimate/internal/database"
+	"tipimate/internal/semver"
+	"tipimate/internal/types"
+	"tipimate/internal/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+func (monitor *Monitor) isFirstCheck() bool {
+	var instance database.Instances
+	res := monitor.Database.Where("name = ?", monitor.Instance.Name).Limit(1).Find(&instance)
+	return res.RowsAffected == 0 || instance.CheckedAt == nil
+}
+
+func (monitor *Monitor) markChecked() {
+	now := time.Now()
+
+	var instance database.Instances
+	monitor.Database.Where(database.Instances{Name: monitor.Instance.Name}).FirstOrCreate(&instance)
+	monitor.Database.Model(&instance).Update("checked_at", &now)
+}
+
+func (monitor *Monitor) checkAppstores(appstores []types.RuntipiAppstore, firstCheck bool) []types.Event {
+	logger := log.With().Str("instance", monitor.Instance.Name).Logger()
+	db := monitor.Database
+
+	var dbAppstores []database.Appstores
+	db.Find(&dbAppstores, "instance = ?", monitor.Instance.Name)
+
+	known := make(map[string]database.Appstores)
+	for _, dbAppstore := range dbAppstores {
+		known[dbAppstore.Slug] = dbAppstore
+	}
+
+	// Runtipi only lists enabled appstores, so a disabled or removed appstore is one that is no longer listed
+	events := []types.Event{}
+	seen := make(map[string]bool)
+
+	for _, appstore := range appstores {
+		seen[appstore.Slug] = true
+		dbAppstore, exists := known[appstore.Slug]
+
+		if !exists {
+			db.Create(&database.Appstores{
+				Instance: monitor.Instance.Name,
+				Slug:     appstore.Slug,
+				Name:     appstore.Name,
+				Url:      appstore.Url,
+				Enabled:  appstore.Enabled,
+			})
+
+			if !firstCheck {
+				logger.Info().Str("appstore", appstore.Slug).Msg("Appstore was enabled or added")
+				events = append(events, monitor.newAppstoreEvent(types.EventAppstoreEnabled, appstore, ""))
+			}
+			continue
+		}
+
+		changes := []string{}
+		if dbAppstore.Name != appstore.Name {
+			changes = append(changes, fmt.Sprintf("Name: %s → %s", dbAppstore.Name, appstore.Name))
+		}
+		if dbAppstore.Url != appstore.Url {
+			changes = append(changes, fmt.Sprintf("URL: %s → %s", dbAppstore.Url, appstore.Url))
+		}
+
+		if len(changes) > 0 {
+			logger.Info().Str("appstore", appstore.Slug).Strs("changes", changes).Msg("Appstore was changed")
+			events = append(events, monitor.newAppstoreEvent(types.EventAppstoreChanged, appstore, strings.Join(changes, "\n")))
+		}
+
+		if len(changes) > 0 {
+			db.Model(&dbAppstore).Updates(map[string]interface{}{"name": appstore.Name, "url": appstore.Url, "enabled": appstore.Enabled})
+		}
+	}
+
+	for _, dbAppstore := range dbAppstores {
+		if seen[dbAppstore.Slug] {
+			continue
+		}
+
+		logger.Info().Str("appstore", dbAppstore.Slug).Msg("Appstore was disabled or removed")
+		db.Unscoped().Delete(&dbAppstore)
+
+		appstore := types.RuntipiAppstore{Slug: dbAppstore.Slug, Name: dbAppstore.Name, Url: dbAppstore.Url}
+		events = append(events, monitor.newAppstoreEvent(types.EventAppstoreDisabled, appstore, ""))
+	}
+
+	return events
+}
+
+func (monitor *Monitor) newAppstoreEvent(eventType string, appstore types.RuntipiAppstore, message string) types.Event {
+	event := monitor.newServerEvent(eventType, message)
+	event.App.Appstore = appstore
+	return event
+}
+
+func (monitor *Monitor) newStoredApp(dbApp *database.Apps) types.App {
+	_, slug := utils.SplitURN(dbApp.Urn)
+
+	app := types.App{
+		Urn:                  dbApp.Urn,
+		Name:                 dbApp.Name,
+		Version:              dbApp.Version,
+		LatestVersion:        dbApp.LatestVersion,
+		DockerVersion:        dbApp.DockerVersion,
+		CurrentDockerVersion: dbApp.CurrentDockerVersion,
+		Appstore: types.RuntipiAppstore{
+			Name: dbApp.Appstore,
+			Slug: slug,
+		},
+		Instance:   monitor.Instance.Name,
+		ServerName: monitor.Instance.ServerName,
+		RuntipiUrl: monitor.Instance.RuntipiUrl,
+	}
+
+	if dbApp.PendingSince != nil {
+		app.UpdateClass = semver.Classify(dbApp.CurrentDockerVersion, dbApp.DockerVersion)
+		app.PendingSince = *dbApp.PendingSince
+		app.LatestSeenAt = *dbApp.PendingSince
+	}
+
+	if dbApp.LatestSeenAt != nil {
+		app.LatestSeenAt = *dbApp.LatestSeenAt
+	}
+
+	if dbApp.Notified && dbApp.NotifiedAt != nil {
+		app.NotifiedAt = *dbApp.NotifiedAt
+	}
+
+	return app
+}
@@ -0,0 +1,396 @@
+package monitor
+
+import (
+	"sync"
+	"time"
+	"tipimate/internal/api"
+	"tipimate/internal/database"
+	"tipimate/internal/history"
+	"tipimate/internal/metrics"
+	"tipimate/internal/rules"
+	"tipimate/internal/semver"
+	"tipimate/internal/types"
+	"tipimate/internal/utils"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+func NewMonitor(config types.MonitorConfig, db *gorm.DB) (*Monitor, error) {
+	instance := config.Instance
+
+	apiConfig := types.APIConfig{
+		RuntipiUrl: instance.RuntipiUrl,
+		Secret:     instance.JwtSecret,
+		Insecure:   instance.Insecure,
+	}
+
+	api, err := api.NewAPI(apiConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Monitor{
+		Instance:             instance,
+		API:                  api,
+		Database:             db,
+		RetryAttempts:        config.RetryAttempts,
+		RetryDelay:           config.RetryDelay,
+		UnreachableThreshold: config.UnreachableThreshold,
+		MinAge:               config.MinAge,
+	}, nil
+}
+
+type Monitor struct {
+	Instance             types.InstanceConfig
+	API                  *api.API
+	Database             *gorm.DB
+	RetryAttempts        int
+	RetryDelay           time.Duration
+	UnreachableThreshold int
+	MinAge               time.Duration
+	failures             int
+	unreachable          bool
+	lastCheckAt          time.Time
+	lastSuccessAt        time.Time
+	lastError            string
+	statusLock           sync.Mutex
+}
+
+func (monitor *Monitor) Check() ([]types.Event, error) {
+	events := []types.Event{}
+
+	started := time.Now()
+	appEvents, err := monitor.checkApps()
+	metrics.RecordCheck(monitor.Instance.Name, time.Since(started), err)
+
+	// The HTTP server reads the status while checks run
+	monitor.statusLock.Lock()
+	defer monitor.statusLock.Unlock()
+
+	monitor.lastCheckAt = time.Now()
+
+	if err != nil {
+		monitor.lastError = err.Error()
+		monitor.failures++
+		log.Warn().Err(err).Str("instance", monitor.Instance.Name).Int("failures", monitor.failures).Msg("Failed to reach runtipi")
+
+		// Only alert once per outage
+		if monitor.UnreachableThreshold > 0 && monitor.failures == monitor.UnreachableThreshold {
+			monitor.unreachable = true
+			events = append(events, monitor.newServerEvent(types.EventUnreachable, err.Error()))
+		}
+
+		return events, err
+	}
+
+	if monitor.unreachable {
+		log.Info().Str("instance", monitor.Instance.Name).Msg("Runtipi is reachable again")
+		events = append(events, monitor.newServerEvent(types.EventRecovered, ""))
+	}
+
+	monitor.failures = 0
+	monitor.unreachable = false
+	monitor.lastSuccessAt = monitor.lastCheckAt
+	monitor.lastError = ""
+
+	pendingApps, err := monitor.GetPendingApps()
+	if err != nil {
+		log.Error().Err(err).Str("instance", monitor.Instance.Name).Msg("Failed to get pending apps")
+	} else {
+		metrics.SetPendingUpdates(monitor.Instance.Name, pendingApps)
+	}
+
+	return append(events, appEvents...), nil
+}
+
+func (monitor *Monitor) checkApps() ([]types.Event, error) {
+	logger := log.With().Str("instance", monitor.Instance.Name).Logger()
+	db := monitor.Database
+
+	logger.Info().Msg("Getting installed apps")
+	apps, err := withRetry(monitor, monitor.API.GetInstalledApps)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info().Msg("Getting appstores")
+	appstores, err := withRetry(monitor, monitor.API.GetAppstores)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first check only records what is there, otherwise every app would be reported as installed
+	firstCheck := monitor.isFirstCheck()
+
+	events := monitor.checkAppstores(appstores.Appstores, firstCheck)
+
+	installedApps := make(map[string]bool)
+	for _, app := range apps.Installed {
+		installedApps[app.Info.Urn] = true
+	}
+
+	var dbApps []database.Apps
+	db.Find(&dbApps, "instance = ?", monitor.Instance.Name)
+
+	for _, dbApp := range dbApps {
+		if !installedApps[dbApp.Urn] {
+			logger.Info().Str("urn", dbApp.Urn).Msg("App was uninstalled, deleting it from the database")
+			db.Unscoped().Delete(&dbApp)
+
+			if !firstCheck {
+				events = append(events, types.Event{Type: types.EventUninstalled, App: monitor.newStoredApp(&dbApp)})
+			}
+		}
+	}
+
+	logger.Info().Msg("Comparing versions")
+
+	endedSnoozes, err := rules.GetEndedSnoozes(db)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get ended snoozes")
+	}
+
+	for _, app := range apps.Installed {
+		var dbApp database.Apps
+		dbRes := db.Limit(1).Find(&dbApp, "instance = ? AND urn = ?", monitor.Instance.Name, app.Info.Urn)
+		isNew := dbRes.RowsAffected == 0
+
+		// Every installed app is stored so uninstalls and applied updates can be noticed
+		if isNew {
+			logger.Debug().Str("urn", app.Info.Urn).Msg("App not found in database, creating new entry")
+
+			installedApp := monitor.newApp(app, appstores.Appstores)
+			now := time.Now()
+
+			dbApp = database.Apps{
+				Instance:             monitor.Instance.Name,
+				Urn:                  app.Info.Urn,
+				Name:                 app.Info.Name,
+				Appstore:             installedApp.Appstore.Name,
+				Version:              app.App.Version,
+				LatestVersion:        app.Metadata.LatestVersion,
+				DockerVersion:        app.Metadata.LatestDockerVersion,
+				CurrentDockerVersion: app.Info.Version,
+				LatestSeenAt:         &now,
+			}
+			db.Create(&dbApp)
+
+			if !firstCheck {
+				logger.Info().Str("urn", app.Info.Urn).Msg("App was installed")
+				events = append(events, types.Event{Type: types.EventInstalled, App: installedApp})
+			}
+		}
+
+		// The installed version moved forward since the last check, so an update was applied
+		if !isNew && app.App.Version > dbApp.Version {
+			logger.Info().Str("urn", app.Info.Urn).Int("from", dbApp.Version).Int("to", app.App.Version).Msg("App was updated")
+			appliedApp := monitor.newApp(app, appstores.Appstores)
+			appliedApp.PreviousVersion = dbApp.Version
+			history.Record(db, history.TypeApplied, &appliedApp, "")
+			events = append(events, types.Event{Type: types.EventApplied, App: appliedApp})
+		}
+
+		// If app is up to date, ignore it
+		if app.App.Version == app.Metadata.LatestVersion {
+			logger.Debug().Str("urn", app.Info.Urn).Msg("App is up to date, ignoring")
+
+			// Clear the pending state so it is no longer part of summaries
+			if dbApp.PendingSince != nil || dbApp.Version != app.App.Version {
+				logger.Debug().Str("urn", app.Info.Urn).Msg("Marking app as up to date in database")
+				updates := map[string]interface{}{"version": app.App.Version, "latest_version": app.Metadata.LatestVersion, "pending_since": nil}
+				if dbApp.LatestVersion != app.Metadata.LatestVersion {
+					updates["latest_seen_at"] = time.Now()
+				}
+				db.Model(&dbApp).Updates(updates)
+			}
+			continue
+		}
+
+		// If app has zeroed verions, ignore it
+		if app.Metadata.LatestDockerVersion == "0.0.0" || app.Metadata.LatestVersion == 0 {
+			logger.Debug().Str("urn", app.Info.Urn).Msg("App has zeroed version, ignoring")
+
+			// The installed version is still stored, otherwise the same applied update is reported on every check
+			if dbApp.Version != app.App.Version {
+				db.Model(&dbApp).Updates(map[string]interface{}{"version": app.App.Version, "current_docker_version": app.Info.Version})
+			}
+			continue
+		}
+
+		logger.Debug().Interface("app", app).Msg("App has an update")
+
+		appWithUpdate := monitor.newApp(app, appstores.Appstores)
+		appWithUpdate.LatestSeenAt = time.Now()
+		notify := false
+
+		// Every new latest version is recorded once, even if it is never notified
+		if isNew || dbApp.PendingSince == nil || dbApp.LatestVersion != app.Metadata.LatestVersion {
+			history.Record(db, history.TypeDetected, &appWithUpdate, "")
+		}
+
+		if isNew {
+			db.Model(&dbApp).Updates(map[string]interface{}{"pending_since": &appWithUpdate.LatestSeenAt, "latest_seen_at": &appWithUpdate.LatestSeenAt})
+			notify = true
+		} else {
+			logger.Debug().Str("urn", app.Info.Urn).Msg("App found in database, checking versions")
+
+			updates := map[string]interface{}{
+				"name":                   app.Info.Name,
+				"appstore":               appWithUpdate.Appstore.Name,
+				"version":                app.App.Version,
+				"latest_version":         app.Metadata.LatestVersion,
+				"docker_version":         app.Metadata.LatestDockerVersion,
+				"current_docker_version": app.Info.Version,
+			}
+
+			if dbApp.PendingSince == nil {
+				updates["pending_since"] = &appWithUpdate.LatestSeenAt
+			}
+
+			// The age of an update starts when its latest version is first seen
+			if dbApp.LatestSeenAt == nil || dbApp.LatestVersion != app.Metadata.LatestVersion {
+				updates["latest_seen_at"] = &appWithUpdate.LatestSeenAt
+			} else {
+				appWithUpdate.LatestSeenAt = *dbApp.LatestSeenAt
+			}
+
+			if dbApp.Version != app.App.Version || dbApp.LatestVersion != app.Metadata.LatestVersion {
+				logger.Debug().Str("urn", app.Info.Urn).Msg("Updating app in database")
+				updates["notified"] = false
+				updates["notified_at"] = nil
+				updates["reminded_at"] = nil
+				updates["escalated_at"] = nil
+				notify = true
+			} else if !dbApp.Notified {
+				// Queued again until the outbox delivered it
+				logger.Debug().Str("urn", app.Info.Urn).Msg("App has not been notified yet")
+				notify = true
+			} else if dbApp.NotifiedAt != nil && rules.SnoozeEnded(endedSnoozes, &appWithUpdate, *dbApp.NotifiedAt) {
+				logger.Info().Str("urn", app.Info.Urn).Msg("Snooze ended, notifying update again")
+				updates["notified"] = false
+				updates["notified_at"] = nil
+				updates["reminded_at"] = nil
+				notify = true
+			}
+
+			db.Model(&dbApp).Updates(updates)
+		}
+
+		if !notify {
+			continue
+		}
+
+		// Young updates stay unnotified, so a later check picks them up once they are old enough
+		if !HasMinAge(&appWithUpdate, monitor.MinAge) {
+			logger.Debug().Str("urn", app.Info.Urn).Time("latestSeenAt", appWithUpdate.LatestSeenAt).Msg("Update is younger than the minimum age, waiting")
+			continue
+		}
+
+		events = append(events, types.Event{Type: types.EventUpdate, App: appWithUpdate})
+	}
+
+	monitor.markChecked()
+
+	return events, nil
+}
+
+func (monitor *Monitor) GetApps() ([]types.App, error) {
+	return monitor.getStoredApps(monitor.Database.Where("instance = ?", monitor.Instance.Name).Order("urn"))
+}
+
+func (monitor *Monitor) GetPendingApps() ([]types.App, error) {
+	return monitor.getStoredApps(monitor.Database.Where("instance = ? AND pending_since IS NOT NULL", monitor.Instance.Name).Order("pending_since"))
+}
+
+func (monitor *Monitor) getStoredApps(query *gorm.DB) ([]types.App, error) {
+	var dbApps []database.Apps
+
+	res := query.Find(&dbApps)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	apps := []types.App{}
+
+	for _, dbApp := range dbApps {
+		apps = append(apps, monitor.newStoredApp(&dbApp))
+	}
+
+	return apps, nil
+}
+
+func (monitor *Monitor) Status() types.ServerStatus {
+	monitor.statusLock.Lock()
+	defer monitor.statusLock.Unlock()
+
+	return types.ServerStatus{
+		Instance:      monitor.Instance.Name,
+		ServerName:    monitor.Instance.ServerName,
+		RuntipiUrl:    monitor.Instance.RuntipiUrl,
+		LastCheckAt:   monitor.lastCheckAt,
+		LastSuccessAt: monitor.lastSuccessAt,
+		LastError:     monitor.lastError,
+		Failures:      monitor.failures,
+		Unreachable:   monitor.unreachable,
+	}
+}
+
+func HasMinAge(app *types.App, minAge time.Duration) bool {
+	return minAge <= 0 || time.Since(app.LatestSeenAt) >= minAge
+}
+
+func withRetry[T any](monitor *Monitor, request func() (T, error)) (T, error) {
+	delay := monitor.RetryDelay
+
+	for attempt := 1; ; attempt++ {
+		res, err := request()
+		if err == nil || attempt >= monitor.RetryAttempts {
+			return res, err
+		}
+
+		log.Debug().Err(err).Str("instance", monitor.Instance.Name).Int("attempt", attempt).Str("delay", delay.String()).Msg("Runtipi request failed, retrying")
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (monitor *Monitor) newServerEvent(eventType string, message string) types.Event {
+	return types.Event{
+		Type: eventType,
+		App: types.App{
+			Instance:   monitor.Instance.Name,
+			ServerName: monitor.Instance.ServerName,
+			RuntipiUrl: monitor.Instance.RuntipiUrl,
+		},
+		Message: message,
+	}
+}
+
+func (monitor *Monitor) newApp(app types.RuntipiApp, appstores []types.RuntipiAppstore) types.App {
+	_, slug := utils.SplitURN(app.Info.Urn)
+	appstore := utils.GetAppstore(appstores, slug)
+
+	if appstore == nil {
+		appstore = &types.RuntipiAppstore{
+			Name:    "Unknown Appstore",
+			Slug:    slug,
+			Url:     "",
+			Enabled: true,
+		}
+	}
+
+	return types.App{
+		Urn:                  app.Info.Urn,
+		Name:                 app.Info.Name,
+		Version:              app.App.Version,
+		LatestVersion:        app.Metadata.LatestVersion,
+		DockerVersion:        app.Metadata.LatestDockerVersion,
+		CurrentDockerVersion: app.Info.Version,
+		UpdateClass:          semver.Classify(app.Info.Version, app.Metadata.LatestDockerVersion),
+		Appstore:             *appstore,
+		Instance:             monitor.Instance.Name,
+		ServerName:           monitor.Instance.ServerName,
+		RuntipiUrl:           monitor.Instance.RuntipiUrl,
+	}
+}
@@ -0,0 +1,141 @@
+package rules
+
+import (
+	"errors"
+	"strconv"
+	"time"
+	"tipimate/internal/database"
+	"tipimate/internal/matcher"
+	"tipimate/internal/types"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Rule kinds
+const (
+	KindIgnore = "ignore"
+	KindSnooze = "snooze"
+)
+
+func GetRules(db *gorm.DB, includeExpired bool) ([]database.Rules, error) {
+	var rules []database.Rules
+
+	query := db.Order("id")
+	if !includeExpired {
+		query = query.Where("until IS NULL OR until > ?", time.Now())
+	}
+
+	res := query.Find(&rules)
+	return rules, res.Error
+}
+
+func AddRule(db *gorm.DB, rule *database.Rules) error {
+	err := ValidateRule(rule)
+	if err != nil {
+		return err
+	}
+
+	res := db.Create(rule)
+	return res.Error
+}
+
+func DeleteRules(db *gorm.DB, ids []uint) (int64, error) {
+	res := db.Unscoped().Where("id IN ?", ids).Delete(&database.Rules{})
+	return res.RowsAffected, res.Error
+}
+
+func ValidateRule(rule *database.Rules) error {
+	// A rule without an app or appstore would silence everything
+	if rule.Urn == "" && rule.Appstore == "" {
+		return errors.New("a rule needs an urn or an appstore")
+	}
+
+	if rule.Urn != "" {
+		return matcher.ValidateGlob(rule.Urn)
+	}
+
+	return nil
+}
+
+func GetEndedSnoozes(db *gorm.DB) ([]database.Rules, error) {
+	var snoozes []database.Rules
+	res := db.Where("until IS NOT NULL AND until <= ?", time.Now()).Find(&snoozes)
+	return snoozes, res.Error
+}
+
+func SnoozeEnded(snoozes []database.Rules, app *types.App, notifiedAt time.Time) bool {
+	// An update that was notified before a matching snooze ended is notified again
+	for _, snooze := range snoozes {
+		if snooze.Until.After(notifiedAt) && matchesRule(&snooze, app) {
+			return true
+		}
+	}
+	return false
+}
+
+func GetKind(rule *database.Rules) string {
+	if rule.Until == nil {
+		return KindIgnore
+	}
+	return KindSnooze
+}
+
+func Match(rules []database.Rules, app *types.App) *database.Rules {
+	for i, rule := range rules {
+		if matchesRule(&rule, app) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+func matchesRule(rule *database.Rules, app *types.App) bool {
+	if !matcher.Matches(getAppMatch(rule), app) {
+		return false
+	}
+
+	// Versions match either the docker version or the tipi version
+	if rule.Version != "" && rule.Version != app.DockerVersion && rule.Version != strconv.Itoa(app.LatestVersion) {
+		return false
+	}
+
+	return true
+}
+
+func getAppMatch(rule *database.Rules) types.AppMatch {
+	match := types.AppMatch{}
+	if rule.Urn != "" {
+		match.Urns = []string{rule.Urn}
+	}
+	if rule.Appstore != "" {
+		match.Appstores = []string{rule.Appstore}
+	}
+	if rule.Server != "" {
+		match.Servers = []string{rule.Server}
+	}
+	return match
+}
+
+func FilterEvents(db *gorm.DB, events []types.Event) []types.Event {
+	rules, err := GetRules(db, false)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get ignore rules")
+		return events
+	}
+
+	filtered := []types.Event{}
+
+	for _, event := range events {
+		// Only pending updates can be ignored, other events are always delivered
+		if event.Type == types.EventUpdate || event.Type == types.EventSummary || event.Type == types.EventReminder {
+			if rule := Match(rules, &event.App); rule != nil {
+				log.Debug().Str("instance", event.App.Instance).Str("urn", event.App.Urn).Uint("rule", rule.ID).Str("kind", GetKind(rule)).Msg("Update matches an ignore rule, skipping")
+				continue
+			}
+		}
+		filtered = append(filtered, event)
+	}
+
+	return filtered
+}
@@ -0,0 +1,95 @@
+package semver
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Update classes
+const (
+	ClassMajor   = "major"
+	ClassMinor   = "minor"
+	ClassPatch   = "patch"
+	ClassUnknown = "unknown"
+)
+
+// Numeric dotted versions with an optional v prefix and an optional suffix like -alpine or +build
+var versionRegex = regexp.MustCompile(`^[vV]?(\d+(?:\.\d+)*)([-+_].*)?$`)
+
+func Parse(version string) ([]int, bool) {
+	matches := versionRegex.FindStringSubmatch(strings.TrimSpace(version))
+	if matches == nil {
+		return nil, false
+	}
+
+	parts := []int{}
+
+	for _, part := range strings.Split(matches[1], ".") {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, false
+		}
+		parts = append(parts, number)
+	}
+
+	return parts, true
+}
+
+func Classify(current string, latest string) string {
+	currentParts, ok := Parse(current)
+	if !ok {
+		return ClassUnknown
+	}
+
+	latestParts, ok := Parse(latest)
+	if !ok {
+		return ClassUnknown
+	}
+
+	// Calver versions like 2024.10.1 follow the same rules, a new year is a major and a new month a minor
+	for i := 0; i < max(len(currentParts), len(latestParts)); i++ {
+		currentPart := getPart(currentParts, i)
+		latestPart := getPart(latestParts, i)
+
+		if latestPart == currentPart {
+			continue
+		}
+
+		// Downgrades can't be classified
+		if latestPart < currentPart {
+			return ClassUnknown
+		}
+
+		switch i {
+		case 0:
+			return ClassMajor
+		case 1:
+			return ClassMinor
+		default:
+			return ClassPatch
+		}
+	}
+
+	// Only the suffix changed, e.g. a rebuilt image
+	if getSuffix(current) != getSuffix(latest) {
+		return ClassPatch
+	}
+
+	return ClassUnknown
+}
+
+func getSuffix(version string) string {
+	matches := versionRegex.FindStringSubmatch(strings.TrimSpace(version))
+	if matches == nil {
+		return ""
+	}
+	return matches[2]
+}
+
+func getPart(parts []int, index int) int {
+	if index < len(parts) {
+		return parts[index]
+	}
+	return 0
+}
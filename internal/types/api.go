@@ -2,13 +2,21 @@ package types
 
 // App status
 type RuntipiAppStatus struct {
-	Version int `json:"version"`
+	Id         int    `json:"id"`
+	Status     string `json:"status"`
+	Version    int    `json:"version"`
+	Exposed    bool   `json:"exposed"`
+	Domain     string `json:"domain"`
+	LastOpened string `json:"lastOpened"`
+	CreatedAt  string `json:"createdAt"`
+	UpdatedAt  string `json:"updatedAt"`
 }
 
 // App info
 type RuntipiAppInfo struct {
-	Name string `json:"name"`
-	Urn  string `json:"urn"`
+	Name    string `json:"name"`
+	Urn     string `json:"urn"`
+	Version string `json:"version"`
 }
 
 // App update info
@@ -37,6 +45,29 @@ type RuntipiAppstore struct {
 	Enabled bool   `json:"enabled"`
 }
 
+// Update app request
+type UpdateAppBody struct {
+	PerformBackup bool `json:"performBackup"`
+}
+
+// Restore app backup request
+type RestoreAppBackupBody struct {
+	Filename string `json:"filename"`
+}
+
+// App backup
+type RuntipiBackup struct {
+	Id   string `json:"id"`
+	Size int64  `json:"size"`
+	Date int64  `json:"date"`
+}
+
+// Get app backups response
+type GetAppBackupsResponse struct {
+	Data  []RuntipiBackup `json:"data"`
+	Total int             `json:"total"`
+}
+
 // Get appstores response
 type GetAppstoresResponse struct {
 	Appstores []RuntipiAppstore `json:"appStores"`
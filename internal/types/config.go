@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 // API config
 type APIConfig struct {
 	RuntipiUrl string
@@ -9,27 +11,179 @@ type APIConfig struct {
 
 // Alerts config
 type AlertsConfig struct {
-	NotificationUrl string
-	RuntipiUrl      string
-	Insecure        bool
-	ServerName      string
+	Targets          []NotificationConfig
+	Insecure         bool
+	Timezone         string
+	MaxAttempts      int
+	ReminderInterval time.Duration
+	EscalateAfter    time.Duration
+	EscalateTo       string
+}
+
+// HTTP server config
+type HttpConfig struct {
+	Address       string
+	HealthAddress string
+	Token         string
+	Username      string
+	Password      string
+	MaxCheckAge   time.Duration
+}
+
+// App match rules, shared by notification targets and update policies
+type AppMatch struct {
+	Urns      []string `mapstructure:"urns"`
+	Appstores []string `mapstructure:"appstores"`
+	Servers   []string `mapstructure:"servers"`
+	Classes   []string `validate:"dive,oneof=major minor patch unknown" mapstructure:"classes"`
+}
+
+// Notification match rules
+type NotificationMatch struct {
+	AppMatch `mapstructure:",squash"`
+	Events   []string `validate:"dive,oneof=update summary unreachable recovered updated update-failed verified unhealthy rolled-back reminder applied installed uninstalled appstore-enabled appstore-disabled appstore-changed" mapstructure:"events"`
+}
+
+// Notification template config
+type NotificationTemplate struct {
+	Title     string `mapstructure:"title"`
+	TitleFile string `validate:"excluded_with=Title" mapstructure:"title-file"`
+	Body      string `mapstructure:"body"`
+	BodyFile  string `validate:"excluded_with=Body" mapstructure:"body-file"`
+}
+
+// Notification digest config
+type NotificationDigest struct {
+	Enabled bool   `mapstructure:"enabled"`
+	GroupBy string `validate:"omitempty,oneof=appstore server" mapstructure:"group-by"`
+}
+
+// Daily time window
+type TimeWindow struct {
+	Start string `validate:"required,datetime=15:04" mapstructure:"start"`
+	End   string `validate:"required,datetime=15:04" mapstructure:"end"`
+}
+
+// Quiet hours config
+type QuietHoursConfig struct {
+	Windows  []TimeWindow `validate:"dive" mapstructure:"windows"`
+	Timezone string       `mapstructure:"timezone"`
+	Deliver  string       `validate:"omitempty,oneof=digest individual" mapstructure:"deliver"`
+}
+
+// Notification target config
+type NotificationConfig struct {
+	Name       string               `validate:"required" mapstructure:"name"`
+	Url        string               `validate:"required" mapstructure:"url"`
+	Match      NotificationMatch    `mapstructure:"match"`
+	Template   NotificationTemplate `mapstructure:"template"`
+	Digest     NotificationDigest   `mapstructure:"digest"`
+	QuietHours QuietHoursConfig     `mapstructure:"quiet-hours"`
+}
+
+// Instance config
+type InstanceConfig struct {
+	Name       string `validate:"required" mapstructure:"name"`
+	RuntipiUrl string `validate:"required" mapstructure:"runtipi-url"`
+	JwtSecret  string `validate:"required" mapstructure:"jwt-secret"`
+	Insecure   bool   `mapstructure:"insecure"`
+	ServerName string `mapstructure:"server-name"`
+}
+
+// Monitor config
+type MonitorConfig struct {
+	Instance             InstanceConfig
+	RetryAttempts        int
+	RetryDelay           time.Duration
+	UnreachableThreshold int
+	MinAge               time.Duration
+}
+
+// Auto update policy rule
+type UpdatePolicy struct {
+	AppMatch `mapstructure:",squash"`
+	Policy   string `validate:"required,oneof=never notify-only auto" mapstructure:"policy"`
+}
+
+// Auto update config
+type AutoUpdateConfig struct {
+	Enabled       bool           `mapstructure:"enabled"`
+	DefaultPolicy string         `validate:"omitempty,oneof=never notify-only auto" mapstructure:"default-policy"`
+	Policies      []UpdatePolicy `validate:"dive" mapstructure:"policies"`
+	Windows       []TimeWindow   `validate:"dive" mapstructure:"windows"`
+	Timezone      string         `mapstructure:"timezone"`
+	MaxConcurrent int            `validate:"min=0" mapstructure:"max-concurrent"`
+	Backup        bool           `mapstructure:"backup"`
+	BackupTimeout time.Duration  `validate:"min=0" mapstructure:"backup-timeout"`
+	MinAge        string         `mapstructure:"min-age"`
+}
+
+// Health check URL for an app
+type HealthCheckUrl struct {
+	Urn    string `validate:"required" mapstructure:"urn"`
+	Server string `mapstructure:"server"`
+	Url    string `validate:"required,url" mapstructure:"url"`
+}
+
+// Post update health check config
+type HealthCheckConfig struct {
+	Enabled  bool             `mapstructure:"enabled"`
+	Timeout  time.Duration    `validate:"min=0" mapstructure:"timeout"`
+	Interval time.Duration    `validate:"min=0" mapstructure:"interval"`
+	Urls     []HealthCheckUrl `validate:"dive" mapstructure:"urls"`
+}
+
+// Updater config
+type UpdaterConfig struct {
+	AutoUpdate  AutoUpdateConfig
+	HealthCheck HealthCheckConfig
+	MinAge      time.Duration
 }
 
 // Server config
 type ServerConfig struct {
-	NotificationUrl string `validate:"required" mapstructure:"notification-url"`
-	RuntipiUrl      string `validate:"required" mapstructure:"runtipi-url"`
-	JwtSecret       string `validate:"required" mapstructure:"jwt-secret"`
-	DatabasePath    string `mapstructure:"database-path"`
-	Interval        int    `mapstructure:"interval"`
-	LogLevel        string `validate:"oneof=trace debug info warn error fatal panic" mapstructure:"log-level"`
-	Insecure        bool   `mapstructure:"insecure"`
-	ServerName      string `mapstructure:"server-name"`
+	NotificationUrl  string               `validate:"required_without=Notifications" mapstructure:"notification-url"`
+	Notifications    []NotificationConfig `validate:"unique=Name,dive" mapstructure:"notifications"`
+	RuntipiUrl       string               `validate:"required_without=Instances" mapstructure:"runtipi-url"`
+	JwtSecret        string               `validate:"required_without=Instances" mapstructure:"jwt-secret"`
+	Instances        []InstanceConfig     `validate:"unique=Name,dive" mapstructure:"instances"`
+	Digest           bool                 `mapstructure:"digest"`
+	DigestGroupBy    string               `validate:"omitempty,oneof=appstore server" mapstructure:"digest-group-by"`
+	SummarySchedule  string               `mapstructure:"summary-schedule"`
+	NotifyApplied    bool                 `mapstructure:"notify-applied"`
+	NotifyLifecycle  bool                 `mapstructure:"notify-lifecycle"`
+	HttpAddress      string               `mapstructure:"http-address"`
+	HttpToken        string               `mapstructure:"http-token"`
+	HttpUsername     string               `validate:"required_with=HttpPassword" mapstructure:"http-username"`
+	HttpPassword     string               `validate:"required_with=HttpUsername" mapstructure:"http-password"`
+	HealthAddress    string               `mapstructure:"health-address"`
+	Timezone         string               `mapstructure:"timezone"`
+	DatabasePath     string               `mapstructure:"database-path"`
+	MaxAttempts      int                  `validate:"min=0" mapstructure:"outbox-max-attempts"`
+	Interval         int                  `validate:"min=1" mapstructure:"interval"`
+	RetryAttempts    int                  `validate:"min=1" mapstructure:"retry-attempts"`
+	RetryDelay       time.Duration        `validate:"min=0" mapstructure:"retry-delay"`
+	Unreachable      int                  `validate:"min=0" mapstructure:"unreachable-threshold"`
+	MinAge           string               `mapstructure:"min-age"`
+	ReminderInterval string               `mapstructure:"reminder-interval"`
+	EscalateAfter    string               `validate:"required_with=EscalateTo" mapstructure:"escalate-after"`
+	EscalateTo       string               `validate:"required_with=EscalateAfter" mapstructure:"escalate-to"`
+	AutoUpdate       AutoUpdateConfig     `mapstructure:"auto-update"`
+	HealthCheck      HealthCheckConfig    `mapstructure:"health-check"`
+	Schedule         string               `mapstructure:"schedule"`
+	Jitter           time.Duration        `validate:"min=0" mapstructure:"jitter"`
+	RunOnStart       bool                 `mapstructure:"run-on-start"`
+	LogLevel         string               `validate:"oneof=trace debug info warn error fatal panic" mapstructure:"log-level"`
+	Insecure         bool                 `mapstructure:"insecure"`
+	ServerName       string               `mapstructure:"server-name"`
 }
 
 // Check config
 type CheckConfig struct {
-	RuntipiUrl string `validate:"required" mapstructure:"runtipi-url"`
-	JwtSecret  string `validate:"required" mapstructure:"jwt-secret"`
-	Insecure   bool   `mapstructure:"insecure"`
+	RuntipiUrl   string `validate:"required" mapstructure:"runtipi-url"`
+	JwtSecret    string `validate:"required" mapstructure:"jwt-secret"`
+	Insecure     bool   `mapstructure:"insecure"`
+	DatabasePath string `mapstructure:"database-path"`
+	Output       string `validate:"oneof=text json yaml table markdown csv" mapstructure:"output"`
+	All          bool   `mapstructure:"all"`
 }
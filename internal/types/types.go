@@ -1,9 +1,83 @@
 package types
 
+import "time"
+
+// Event types
+const (
+	EventUpdate           = "update"
+	EventSummary          = "summary"
+	EventUnreachable      = "unreachable"
+	EventRecovered        = "recovered"
+	EventUpdated          = "updated"
+	EventFailed           = "update-failed"
+	EventApplied          = "applied"
+	EventVerified         = "verified"
+	EventUnhealthy        = "unhealthy"
+	EventRolledBack       = "rolled-back"
+	EventReminder         = "reminder"
+	EventInstalled        = "installed"
+	EventUninstalled      = "uninstalled"
+	EventAppstoreEnabled  = "appstore-enabled"
+	EventAppstoreDisabled = "appstore-disabled"
+	EventAppstoreChanged  = "appstore-changed"
+	EventTest             = "test"
+)
+
 // App type
 type App struct {
-	Name          string
-	Urn           string
-	Version       int
-	DockerVersion string
+	Name                 string
+	Urn                  string
+	Version              int
+	PreviousVersion      int
+	LatestVersion        int
+	DockerVersion        string
+	CurrentDockerVersion string
+	UpdateClass          string
+	Appstore             RuntipiAppstore
+	Instance             string
+	ServerName           string
+	RuntipiUrl           string
+	PendingSince         time.Time
+	LatestSeenAt         time.Time
+	NotifiedAt           time.Time
+}
+
+// Server status
+type ServerStatus struct {
+	Instance      string
+	ServerName    string
+	RuntipiUrl    string
+	LastCheckAt   time.Time
+	LastSuccessAt time.Time
+	LastError     string
+	Failures      int
+	Unreachable   bool
+}
+
+// Event type
+type Event struct {
+	Type    string
+	App     App
+	Message string
+}
+
+// Template data
+type TemplateData struct {
+	Event                string
+	Name                 string
+	Urn                  string
+	Appstore             string
+	AppstoreSlug         string
+	Version              int
+	PreviousVersion      int
+	LatestVersion        int
+	DockerVersion        string
+	CurrentDockerVersion string
+	UpdateClass          string
+	ServerName           string
+	Instance             string
+	RuntipiUrl           string
+	AppUrl               string
+	Message              string
+	PendingFor           string
 }
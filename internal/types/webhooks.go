@@ -9,7 +9,7 @@ type DiscordEmbedFooter struct {
 type DiscordEmbed struct {
 	Title       string             `json:"title"`
 	Description string             `json:"description"`
-	Url         string             `json:"url"`
+	Url         string             `json:"url,omitempty"`
 	Color       string             `json:"color"`
 	Footer      DiscordEmbedFooter `json:"footer"`
 	Timestamp   string             `json:"timestamp"`
@@ -0,0 +1,288 @@
+package updater
+
+import (
+	"net/http"
+	"sync"
+	"time"
+	"tipimate/internal/database"
+	"tipimate/internal/matcher"
+	"tipimate/internal/monitor"
+	"tipimate/internal/rules"
+	"tipimate/internal/types"
+	"tipimate/internal/utils"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Update policies
+const (
+	PolicyNever      = "never"
+	PolicyNotifyOnly = "notify-only"
+	PolicyAuto       = "auto"
+)
+
+// Update statuses
+const (
+	UpdateRunning    = "running"
+	UpdateSucceeded  = "succeeded"
+	UpdateFailed     = "failed"
+	UpdateRolledBack = "rolled-back"
+)
+
+// Health check and backup defaults
+const (
+	defaultHealthTimeout  = 10 * time.Minute
+	defaultHealthInterval = 15 * time.Second
+	defaultBackupTimeout  = 30 * time.Minute
+)
+
+func NewUpdater(updaterConfig types.UpdaterConfig, monitors []*monitor.Monitor, db *gorm.DB) (*Updater, error) {
+	config := updaterConfig.AutoUpdate
+
+	location, err := utils.GetLocation(config.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultPolicy := config.DefaultPolicy
+	if defaultPolicy == "" {
+		defaultPolicy = PolicyNotifyOnly
+	}
+
+	// Updates run one at a time unless told otherwise
+	maxConcurrent := config.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+
+	backupTimeout := config.BackupTimeout
+	if backupTimeout == 0 {
+		backupTimeout = defaultBackupTimeout
+	}
+
+	healthCheck := updaterConfig.HealthCheck
+	if healthCheck.Timeout == 0 {
+		healthCheck.Timeout = defaultHealthTimeout
+	}
+	if healthCheck.Interval == 0 {
+		healthCheck.Interval = defaultHealthInterval
+	}
+
+	// One client per instance, so health checks reuse their connections
+	healthClients := make(map[string]*http.Client)
+	for _, instanceMonitor := range monitors {
+		healthClients[instanceMonitor.Instance.Name] = newHealthClient(instanceMonitor.Instance.Insecure)
+	}
+
+	return &Updater{
+		Enabled:       config.Enabled,
+		DefaultPolicy: defaultPolicy,
+		Policies:      config.Policies,
+		Windows:       config.Windows,
+		MaxConcurrent: maxConcurrent,
+		MinAge:        updaterConfig.MinAge,
+		Backup:        config.Backup,
+		BackupTimeout: backupTimeout,
+		HealthCheck:   healthCheck,
+		Monitors:      monitors,
+		Database:      db,
+		location:      location,
+		verifying:     make(map[string]bool),
+		healthClients: healthClients,
+	}, nil
+}
+
+type Updater struct {
+	Enabled       bool
+	DefaultPolicy string
+	Policies      []types.UpdatePolicy
+	Windows       []types.TimeWindow
+	MaxConcurrent int
+	MinAge        time.Duration
+	Backup        bool
+	BackupTimeout time.Duration
+	HealthCheck   types.HealthCheckConfig
+	Monitors      []*monitor.Monitor
+	Database      *gorm.DB
+	location      *time.Location
+	runLock       sync.Mutex
+	verifying     map[string]bool
+	verifyLock    sync.Mutex
+	healthClients map[string]*http.Client
+}
+
+func (updater *Updater) GetPolicy(app *types.App) string {
+	// Policies only apply once auto updates are enabled
+	if !updater.Enabled {
+		return PolicyNotifyOnly
+	}
+
+	// First matching policy wins
+	for _, policy := range updater.Policies {
+		if matcher.Matches(policy.AppMatch, app) {
+			return policy.Policy
+		}
+	}
+
+	return updater.DefaultPolicy
+}
+
+func (updater *Updater) FilterEvents(events []types.Event) []types.Event {
+	filtered := []types.Event{}
+
+	for _, event := range events {
+		// Server events are not about an app
+		if event.App.Urn != "" && updater.GetPolicy(&event.App) == PolicyNever {
+			log.Debug().Str("instance", event.App.Instance).Str("urn", event.App.Urn).Msg("App has the never policy, skipping")
+			continue
+		}
+		filtered = append(filtered, event)
+	}
+
+	return filtered
+}
+
+func (updater *Updater) Run() []types.Event {
+	if !updater.Enabled {
+		return nil
+	}
+
+	// Checks and the scheduler may both trigger a run
+	if !updater.runLock.TryLock() {
+		log.Debug().Msg("Auto update already running, skipping")
+		return nil
+	}
+	defer updater.runLock.Unlock()
+
+	if len(updater.Windows) > 0 {
+		_, inWindow := utils.GetWindowsEnd(updater.Windows, time.Now().In(updater.location))
+		if !inWindow {
+			log.Debug().Msg("Outside of the maintenance window, skipping auto update")
+			return nil
+		}
+	}
+
+	activeRules, err := rules.GetRules(updater.Database, false)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get ignore rules")
+		return nil
+	}
+
+	jobs := []updateJob{}
+
+	for _, instanceMonitor := range updater.Monitors {
+		apps, err := instanceMonitor.GetPendingApps()
+		if err != nil {
+			log.Error().Err(err).Str("instance", instanceMonitor.Instance.Name).Msg("Failed to get pending apps")
+			continue
+		}
+
+		for _, app := range apps {
+			if updater.GetPolicy(&app) != PolicyAuto {
+				continue
+			}
+
+			if !monitor.HasMinAge(&app, updater.MinAge) {
+				log.Debug().Str("instance", app.Instance).Str("urn", app.Urn).Msg("Update is younger than the minimum age, skipping")
+				continue
+			}
+
+			if rules.Match(activeRules, &app) != nil {
+				log.Debug().Str("instance", app.Instance).Str("urn", app.Urn).Msg("Update matches an ignore rule, skipping")
+				continue
+			}
+
+			// Every version is only attempted once, failed updates are left to the user
+			var attempts int64
+			updater.Database.Model(&database.Updates{}).Where("instance = ? AND urn = ? AND latest_version = ?", app.Instance, app.Urn, app.LatestVersion).Count(&attempts)
+
+			if attempts != 0 {
+				log.Debug().Str("instance", app.Instance).Str("urn", app.Urn).Msg("Update already attempted, skipping")
+				continue
+			}
+
+			record, err := updater.createRecord(&app)
+			if err != nil {
+				log.Error().Err(err).Str("instance", app.Instance).Str("urn", app.Urn).Msg("Failed to create update record")
+				continue
+			}
+
+			jobs = append(jobs, updateJob{Monitor: instanceMonitor, App: app, Record: record})
+		}
+	}
+
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	log.Info().Int("apps", len(jobs)).Int("maxConcurrent", updater.MaxConcurrent).Msg("Auto updating apps")
+
+	return updater.runJobs(jobs)
+}
+
+func (updater *Updater) Resume() []types.Event {
+	// Hold off new updates until the interrupted ones are done
+	updater.runLock.Lock()
+	defer updater.runLock.Unlock()
+
+	var records []database.Updates
+
+	res := updater.Database.Where("status = ?", UpdateRunning).Order("id").Find(&records)
+	if res.Error != nil {
+		log.Error().Err(res.Error).Msg("Failed to get interrupted updates")
+		return nil
+	}
+
+	jobs := []updateJob{}
+	events := []types.Event{}
+
+	for i := range records {
+		record := &records[i]
+
+		instanceMonitor := updater.getMonitor(record.Instance)
+		if instanceMonitor == nil {
+			log.Warn().Str("instance", record.Instance).Str("urn", record.Urn).Msg("Instance of interrupted update is no longer configured, marking it as failed")
+			updater.finishRecord(record, UpdateFailed, "instance is no longer configured")
+			continue
+		}
+
+		app := newRecordApp(record, instanceMonitor)
+
+		// Without a step to pick up from there is nothing left to resume
+		if record.Step == "" {
+			updater.finishRecord(record, UpdateFailed, "update was interrupted by a restart")
+			events = append(events, types.Event{Type: types.EventFailed, App: app, Message: record.Error})
+			continue
+		}
+
+		jobs = append(jobs, updateJob{Monitor: instanceMonitor, App: app, Record: record})
+	}
+
+	if len(jobs) > 0 {
+		log.Info().Int("apps", len(jobs)).Msg("Resuming interrupted updates")
+		events = append(events, updater.runJobs(jobs)...)
+	}
+
+	return events
+}
+
+func (updater *Updater) runJobs(jobs []updateJob) []types.Event {
+	events := make([]types.Event, len(jobs))
+	slots := make(chan struct{}, updater.MaxConcurrent)
+	wg := sync.WaitGroup{}
+
+	for i, job := range jobs {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, job updateJob) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			events[i] = updater.runWorkflow(job)
+		}(i, job)
+	}
+
+	wg.Wait()
+
+	return events
+}
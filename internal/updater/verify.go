@@ -0,0 +1,196 @@
+package updater
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+	"tipimate/internal/database"
+	"tipimate/internal/monitor"
+	"tipimate/internal/types"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Runtipi app status of a healthy app
+const appRunning = "running"
+
+func (updater *Updater) VerifyApplied(events []types.Event) []types.Event {
+	if !updater.HealthCheck.Enabled {
+		return nil
+	}
+
+	results := make([]types.Event, len(events))
+	wg := sync.WaitGroup{}
+
+	for i, event := range events {
+		if event.Type != types.EventApplied {
+			continue
+		}
+
+		// Auto updates are verified by the updater itself
+		if updater.IsAutoUpdate(&event.App) {
+			continue
+		}
+
+		instanceMonitor := updater.getMonitor(event.App.Instance)
+		if instanceMonitor == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, app types.App) {
+			defer wg.Done()
+			results[i] = updater.verifyApp(instanceMonitor, app, app.Version)
+		}(i, event.App)
+	}
+
+	wg.Wait()
+
+	verified := []types.Event{}
+	for _, event := range results {
+		if event.Type != "" {
+			verified = append(verified, event)
+		}
+	}
+
+	return verified
+}
+
+func (updater *Updater) IsAutoUpdate(app *types.App) bool {
+	var autoUpdates int64
+	updater.Database.Model(&database.Updates{}).Where("instance = ? AND urn = ? AND latest_version = ?", app.Instance, app.Urn, app.Version).Count(&autoUpdates)
+	return autoUpdates != 0
+}
+
+func (updater *Updater) verifyApp(instanceMonitor *monitor.Monitor, app types.App, version int) types.Event {
+	// The same update may be noticed again by the next check while it is still followed
+	key := fmt.Sprintf("%s/%s/%d", app.Instance, app.Urn, version)
+
+	updater.verifyLock.Lock()
+	if updater.verifying[key] {
+		updater.verifyLock.Unlock()
+		return types.Event{}
+	}
+	updater.verifying[key] = true
+	updater.verifyLock.Unlock()
+
+	defer func() {
+		updater.verifyLock.Lock()
+		delete(updater.verifying, key)
+		updater.verifyLock.Unlock()
+	}()
+
+	err := updater.waitHealthy(instanceMonitor, app, version, false)
+	if err != nil {
+		return types.Event{Type: types.EventUnhealthy, App: app, Message: err.Error()}
+	}
+
+	return types.Event{Type: types.EventVerified, App: app}
+}
+
+func (updater *Updater) waitHealthy(instanceMonitor *monitor.Monitor, app types.App, version int, exact bool) error {
+	logger := log.With().Str("instance", app.Instance).Str("urn", app.Urn).Int("version", version).Logger()
+	logger.Info().Str("timeout", updater.HealthCheck.Timeout.String()).Msg("Verifying app health")
+
+	healthUrl := updater.getHealthUrl(&app)
+	deadline := time.Now().Add(updater.HealthCheck.Timeout)
+
+	for {
+		err := checkHealth(updater.healthClients[instanceMonitor.Instance.Name], instanceMonitor, app.Urn, version, exact, healthUrl)
+		if err == nil {
+			logger.Info().Msg("App is healthy")
+			return nil
+		}
+
+		if time.Now().Add(updater.HealthCheck.Interval).After(deadline) {
+			logger.Error().Err(err).Msg("App failed to become healthy")
+			return err
+		}
+
+		logger.Debug().Err(err).Msg("App is not healthy yet")
+		time.Sleep(updater.HealthCheck.Interval)
+	}
+}
+
+func checkHealth(client *http.Client, instanceMonitor *monitor.Monitor, urn string, version int, exact bool, healthUrl string) error {
+	status, err := getAppStatus(instanceMonitor, urn)
+	if err != nil {
+		return err
+	}
+
+	if status.Version < version || (exact && status.Version != version) {
+		return fmt.Errorf("app is still on version %d", status.Version)
+	}
+
+	if status.Status != appRunning {
+		return fmt.Errorf("app status is %s", status.Status)
+	}
+
+	if healthUrl == "" {
+		return nil
+	}
+
+	res, err := client.Get(healthUrl)
+	if err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	// Draining the body lets the connection be reused by the next poll
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("health check failed with status code: %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func newHealthClient(insecure bool) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure, MinVersion: tls.VersionTLS12},
+		},
+	}
+}
+
+func getAppStatus(instanceMonitor *monitor.Monitor, urn string) (*types.RuntipiAppStatus, error) {
+	apps, err := instanceMonitor.API.GetInstalledApps()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, app := range apps.Installed {
+		if app.Info.Urn == urn {
+			return &app.App, nil
+		}
+	}
+
+	return nil, fmt.Errorf("app is no longer installed")
+}
+
+func (updater *Updater) getHealthUrl(app *types.App) string {
+	for _, healthCheck := range updater.HealthCheck.Urls {
+		if healthCheck.Urn != app.Urn {
+			continue
+		}
+		if healthCheck.Server != "" && healthCheck.Server != app.Instance && healthCheck.Server != app.ServerName {
+			continue
+		}
+		return healthCheck.Url
+	}
+	return ""
+}
+
+func (updater *Updater) getMonitor(instance string) *monitor.Monitor {
+	for _, instanceMonitor := range updater.Monitors {
+		if instanceMonitor.Instance.Name == instance {
+			return instanceMonitor
+		}
+	}
+	return nil
+}
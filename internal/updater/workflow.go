@@ -0,0 +1,273 @@
+package updater
+
+import (
+	"fmt"
+	"time"
+	"tipimate/internal/database"
+	"tipimate/internal/monitor"
+	"tipimate/internal/types"
+	"tipimate/internal/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Update workflow steps, stored on the record so a restart picks up where it stopped
+const (
+	StepBackup  = "backup"
+	StepUpdate  = "update"
+	StepVerify  = "verify"
+	StepRestore = "restore"
+)
+
+// Runtipi app statuses while a backup is created and while an update is applied
+const (
+	appBackingUp = "backing_up"
+	appUpdating  = "updating"
+)
+
+type updateJob struct {
+	Monitor *monitor.Monitor
+	App     types.App
+	Record  *database.Updates
+}
+
+func (updater *Updater) createRecord(app *types.App) (*database.Updates, error) {
+	step := StepUpdate
+	if updater.Backup {
+		step = StepBackup
+	}
+
+	record := &database.Updates{
+		Instance:      app.Instance,
+		Urn:           app.Urn,
+		Name:          app.Name,
+		Appstore:      app.Appstore.Name,
+		Version:       app.Version,
+		LatestVersion: app.LatestVersion,
+		DockerVersion: app.DockerVersion,
+		Status:        UpdateRunning,
+		Step:          step,
+	}
+
+	res := updater.Database.Create(record)
+	return record, res.Error
+}
+
+func (updater *Updater) setStep(record *database.Updates, step string) {
+	record.Step = step
+	updater.Database.Model(record).Updates(database.Updates{Step: step, Backup: record.Backup, Error: record.Error})
+}
+
+func (updater *Updater) finishRecord(record *database.Updates, status string, message string) {
+	now := time.Now()
+	record.Status = status
+	record.Error = message
+	updater.Database.Model(record).Updates(map[string]interface{}{"status": status, "error": message, "finished_at": &now})
+}
+
+func (updater *Updater) runWorkflow(job updateJob) types.Event {
+	app := job.App
+	record := job.Record
+	logger := log.With().Str("instance", app.Instance).Str("urn", app.Urn).Logger()
+
+	for {
+		switch record.Step {
+		case StepBackup:
+			logger.Info().Msg("Backing up app before update")
+
+			backup, err := updater.backupApp(job.Monitor, app.Urn, record.CreatedAt)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to back up app, skipping update")
+				updater.finishRecord(record, UpdateFailed, fmt.Sprintf("backup failed: %s", err))
+				return types.Event{Type: types.EventFailed, App: app, Message: record.Error}
+			}
+
+			logger.Info().Str("backup", backup).Msg("App backed up")
+			record.Backup = backup
+			updater.setStep(record, StepUpdate)
+
+		case StepUpdate:
+			// A resumed update may have been sent before the restart
+			status, err := getAppStatus(job.Monitor, app.Urn)
+			if err == nil && status.Version >= app.LatestVersion {
+				logger.Debug().Msg("App already runs the new version, skipping update request")
+			} else {
+				logger.Info().Str("dockerVersion", app.DockerVersion).Msg("Updating app")
+
+				err = job.Monitor.API.UpdateApp(app.Urn)
+				if err != nil {
+					logger.Error().Err(err).Msg("Failed to update app")
+					updater.finishRecord(record, UpdateFailed, err.Error())
+					return types.Event{Type: types.EventFailed, App: app, Message: err.Error()}
+				}
+			}
+
+			// A backup is only useful if a failed update is noticed, so backed up apps are always verified
+			if !updater.HealthCheck.Enabled && record.Backup == "" {
+				// Runtipi accepts the update right away, the outcome is only known once it finished
+				err = updater.waitUpdated(job.Monitor, app)
+				if err != nil {
+					logger.Error().Err(err).Msg("Update did not finish")
+					updater.finishRecord(record, UpdateFailed, err.Error())
+					return types.Event{Type: types.EventFailed, App: app, Message: err.Error()}
+				}
+
+				logger.Info().Msg("App updated")
+				updater.finishRecord(record, UpdateSucceeded, "")
+				return types.Event{Type: types.EventUpdated, App: app}
+			}
+
+			updater.setStep(record, StepVerify)
+
+		case StepVerify:
+			err := updater.waitHealthy(job.Monitor, app, app.LatestVersion, false)
+			if err == nil {
+				updater.finishRecord(record, UpdateSucceeded, "")
+				if !updater.HealthCheck.Enabled {
+					return types.Event{Type: types.EventUpdated, App: app}
+				}
+				return types.Event{Type: types.EventVerified, App: app}
+			}
+
+			if record.Backup == "" {
+				updater.finishRecord(record, UpdateFailed, err.Error())
+				return types.Event{Type: types.EventUnhealthy, App: app, Message: err.Error()}
+			}
+
+			record.Error = err.Error()
+			updater.setStep(record, StepRestore)
+
+		case StepRestore:
+			logger.Warn().Str("backup", record.Backup).Msg("Restoring backup after failed update")
+
+			err := updater.restoreBackup(job.Monitor, app, record.Backup)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to restore backup")
+				message := fmt.Sprintf("%s, restoring backup %s failed: %s", record.Error, record.Backup, err)
+				updater.finishRecord(record, UpdateFailed, message)
+				return types.Event{Type: types.EventUnhealthy, App: app, Message: message}
+			}
+
+			logger.Info().Msg("Backup restored")
+			message := fmt.Sprintf("%s, restored backup %s", record.Error, record.Backup)
+			updater.finishRecord(record, UpdateRolledBack, message)
+			return types.Event{Type: types.EventRolledBack, App: app, Message: message}
+
+		default:
+			updater.finishRecord(record, UpdateFailed, fmt.Sprintf("unknown update step %q", record.Step))
+			return types.Event{Type: types.EventFailed, App: app, Message: record.Error}
+		}
+	}
+}
+
+func (updater *Updater) waitUpdated(instanceMonitor *monitor.Monitor, app types.App) error {
+	deadline := time.Now().Add(updater.HealthCheck.Timeout)
+
+	for {
+		status, err := getAppStatus(instanceMonitor, app.Urn)
+		if err != nil {
+			return err
+		}
+
+		if status.Version >= app.LatestVersion && status.Status != appUpdating {
+			return nil
+		}
+
+		if time.Now().Add(updater.HealthCheck.Interval).After(deadline) {
+			if status.Status == appUpdating {
+				return fmt.Errorf("update did not finish within %s", updater.HealthCheck.Timeout)
+			}
+			return fmt.Errorf("app is still on version %d", status.Version)
+		}
+
+		time.Sleep(updater.HealthCheck.Interval)
+	}
+}
+
+func (updater *Updater) backupApp(instanceMonitor *monitor.Monitor, urn string, since time.Time) (string, error) {
+	deadline := time.Now().Add(updater.BackupTimeout)
+	requested := false
+
+	for {
+		status, err := getAppStatus(instanceMonitor, urn)
+		if err != nil {
+			return "", err
+		}
+
+		backup, err := getBackupSince(instanceMonitor, urn, since)
+		if err != nil {
+			return "", err
+		}
+
+		if status.Status != appBackingUp {
+			if backup != "" {
+				return backup, nil
+			}
+
+			// Also covers resumed updates whose backup never started
+			if !requested {
+				err = instanceMonitor.API.BackupApp(urn)
+				if err != nil {
+					return "", err
+				}
+				requested = true
+			}
+		}
+
+		if time.Now().Add(updater.HealthCheck.Interval).After(deadline) {
+			return "", fmt.Errorf("backup did not finish within %s", updater.BackupTimeout)
+		}
+
+		time.Sleep(updater.HealthCheck.Interval)
+	}
+}
+
+func getBackupSince(instanceMonitor *monitor.Monitor, urn string, since time.Time) (string, error) {
+	backups, err := instanceMonitor.API.GetAppBackups(urn)
+	if err != nil {
+		return "", err
+	}
+
+	// Backup dates are in milliseconds, second precision avoids missing a backup made right after the record
+	var latest *types.RuntipiBackup
+	for i, backup := range backups.Data {
+		if backup.Date/1000 >= since.Unix() && (latest == nil || backup.Date > latest.Date) {
+			latest = &backups.Data[i]
+		}
+	}
+
+	if latest == nil {
+		return "", nil
+	}
+
+	return latest.Id, nil
+}
+
+func (updater *Updater) restoreBackup(instanceMonitor *monitor.Monitor, app types.App, backup string) error {
+	err := instanceMonitor.API.RestoreAppBackup(app.Urn, backup)
+	if err != nil {
+		return err
+	}
+
+	// The backup was taken before the update, so the app should be back on its old version
+	return updater.waitHealthy(instanceMonitor, app, app.Version, true)
+}
+
+func newRecordApp(record *database.Updates, instanceMonitor *monitor.Monitor) types.App {
+	_, slug := utils.SplitURN(record.Urn)
+
+	return types.App{
+		Urn:           record.Urn,
+		Name:          record.Name,
+		Version:       record.Version,
+		LatestVersion: record.LatestVersion,
+		DockerVersion: record.DockerVersion,
+		Appstore: types.RuntipiAppstore{
+			Name: record.Appstore,
+			Slug: slug,
+		},
+		Instance:   instanceMonitor.Instance.Name,
+		ServerName: instanceMonitor.Instance.ServerName,
+		RuntipiUrl: instanceMonitor.Instance.RuntipiUrl,
+	}
+}
@@ -1,9 +1,15 @@
 package utils
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
+	"time"
 	"tipimate/internal/types"
 
+	// Embed timezone data since the docker image does not ship it
+	_ "time/tzdata"
+
 	"github.com/rs/zerolog"
 )
 
@@ -49,3 +55,57 @@ func GetAppstore(appstores []types.RuntipiAppstore, slug string) *types.RuntipiA
 	}
 	return nil
 }
+
+func GetAppUrl(app *types.App) string {
+	// Build the app page URL on the runtipi dashboard
+	id, _ := SplitURN(app.Urn)
+	return fmt.Sprintf("%s/apps/%s/%s", app.RuntipiUrl, app.Appstore.Slug, id)
+}
+
+func ParseDuration(value string) (time.Duration, error) {
+	// Go durations stop at hours, snoozes are usually given in days
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		count, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func GetLocation(name string) (*time.Location, error) {
+	// Empty timezone means the local timezone of the machine (or TZ variable)
+	if name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
+func GetWindowsEnd(windows []types.TimeWindow, now time.Time) (time.Time, bool) {
+	// Returns the end of the window now falls in, if any
+	for _, window := range windows {
+		start, _ := time.Parse("15:04", window.Start)
+		end, _ := time.Parse("15:04", window.End)
+
+		startToday := time.Date(now.Year(), now.Month(), now.Day(), start.Hour(), start.Minute(), 0, 0, now.Location())
+		endToday := time.Date(now.Year(), now.Month(), now.Day(), end.Hour(), end.Minute(), 0, 0, now.Location())
+
+		switch {
+		case startToday.Before(endToday):
+			if !now.Before(startToday) && now.Before(endToday) {
+				return endToday, true
+			}
+		case startToday.After(endToday):
+			// Window spans midnight, e.g. 22:00 to 07:00
+			if !now.Before(startToday) {
+				return endToday.AddDate(0, 0, 1), true
+			}
+			if now.Before(endToday) {
+				return endToday, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,252 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+	"tipimate/internal/database"
+	"tipimate/internal/matcher"
+	"tipimate/internal/monitor"
+	"tipimate/internal/rules"
+	"tipimate/internal/types"
+	"tipimate/internal/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type appResponse struct {
+	Instance             string     `json:"instance"`
+	ServerName           string     `json:"serverName"`
+	Urn                  string     `json:"urn"`
+	Name                 string     `json:"name"`
+	Appstore             string     `json:"appstore"`
+	AppstoreSlug         string     `json:"appstoreSlug"`
+	Version              int        `json:"version"`
+	LatestVersion        int        `json:"latestVersion"`
+	DockerVersion        string     `json:"dockerVersion"`
+	CurrentDockerVersion string     `json:"currentDockerVersion"`
+	UpdateAvailable      bool       `json:"updateAvailable"`
+	UpdateClass          string     `json:"updateClass,omitempty"`
+	AppUrl               string     `json:"appUrl"`
+	PendingSince         *time.Time `json:"pendingSince,omitempty"`
+	LatestSeenAt         *time.Time `json:"latestSeenAt,omitempty"`
+	NotifiedAt           *time.Time `json:"notifiedAt,omitempty"`
+	Ignored              bool       `json:"ignored"`
+}
+
+type serverResponse struct {
+	Instance       string     `json:"instance"`
+	ServerName     string     `json:"serverName"`
+	RuntipiUrl     string     `json:"runtipiUrl"`
+	LastCheckAt    *time.Time `json:"lastCheckAt,omitempty"`
+	LastSuccessAt  *time.Time `json:"lastSuccessAt,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	Failures       int        `json:"failures"`
+	Unreachable    bool       `json:"unreachable"`
+	Apps           int        `json:"apps"`
+	PendingUpdates int        `json:"pendingUpdates"`
+}
+
+type statusResponse struct {
+	LastCheckAt *time.Time       `json:"lastCheckAt,omitempty"`
+	Success     bool             `json:"success"`
+	Servers     []serverResponse `json:"servers"`
+}
+
+type checkResponse struct {
+	Queued  bool   `json:"queued"`
+	Message string `json:"message"`
+}
+
+type testNotificationRequest struct {
+	Target string `json:"target"`
+}
+
+type testNotificationResponse struct {
+	Sent  int    `json:"sent"`
+	Error string `json:"error,omitempty"`
+}
+
+func (server *Server) getApps(w http.ResponseWriter, r *http.Request) {
+	apps, ok := server.collectApps(w, r, (*monitor.Monitor).GetApps)
+	if ok {
+		writeJson(w, http.StatusOK, apps)
+	}
+}
+
+func (server *Server) getUpdates(w http.ResponseWriter, r *http.Request) {
+	apps, ok := server.collectApps(w, r, (*monitor.Monitor).GetPendingApps)
+	if ok {
+		writeJson(w, http.StatusOK, apps)
+	}
+}
+
+func (server *Server) collectApps(w http.ResponseWriter, r *http.Request, getApps func(*monitor.Monitor) ([]types.App, error)) ([]appResponse, bool) {
+	serverFilter := r.URL.Query().Get("server")
+	urnFilter := r.URL.Query().Get("urn")
+
+	if urnFilter != "" {
+		err := matcher.ValidateGlob(urnFilter)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid urn pattern")
+			return nil, false
+		}
+	}
+
+	ignoreRules, err := rules.GetRules(server.Database, false)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get ignore rules")
+		writeError(w, http.StatusInternalServerError, "Failed to get ignore rules")
+		return nil, false
+	}
+
+	response := []appResponse{}
+
+	for _, instanceMonitor := range server.Monitors {
+		if serverFilter != "" && serverFilter != instanceMonitor.Instance.Name && serverFilter != instanceMonitor.Instance.ServerName {
+			continue
+		}
+
+		apps, err := getApps(instanceMonitor)
+		if err != nil {
+			log.Error().Err(err).Str("instance", instanceMonitor.Instance.Name).Msg("Failed to get apps")
+			writeError(w, http.StatusInternalServerError, "Failed to get apps")
+			return nil, false
+		}
+
+		for _, app := range apps {
+			if urnFilter != "" {
+				if !matcher.MatchesGlob(urnFilter, app.Urn) {
+					continue
+				}
+			}
+			response = append(response, newAppResponse(&app, ignoreRules))
+		}
+	}
+
+	return response, true
+}
+
+func (server *Server) getStatus(w http.ResponseWriter, r *http.Request) {
+	response := statusResponse{
+		Success: true,
+		Servers: []serverResponse{},
+	}
+
+	var lastCheckAt time.Time
+
+	for _, instanceMonitor := range server.Monitors {
+		status := instanceMonitor.Status()
+
+		apps, err := instanceMonitor.GetApps()
+		if err != nil {
+			log.Error().Err(err).Str("instance", status.Instance).Msg("Failed to get apps")
+			writeError(w, http.StatusInternalServerError, "Failed to get apps")
+			return
+		}
+
+		pending := 0
+		for _, app := range apps {
+			if !app.PendingSince.IsZero() {
+				pending++
+			}
+		}
+
+		// A server that was never checked has no result yet
+		if status.LastCheckAt.IsZero() || status.LastError != "" {
+			response.Success = false
+		}
+
+		if status.LastCheckAt.After(lastCheckAt) {
+			lastCheckAt = status.LastCheckAt
+		}
+
+		response.Servers = append(response.Servers, serverResponse{
+			Instance:       status.Instance,
+			ServerName:     status.ServerName,
+			RuntipiUrl:     status.RuntipiUrl,
+			LastCheckAt:    timePtr(status.LastCheckAt),
+			LastSuccessAt:  timePtr(status.LastSuccessAt),
+			LastError:      status.LastError,
+			Failures:       status.Failures,
+			Unreachable:    status.Unreachable,
+			Apps:           len(apps),
+			PendingUpdates: pending,
+		})
+	}
+
+	response.LastCheckAt = timePtr(lastCheckAt)
+
+	writeJson(w, http.StatusOK, response)
+}
+
+func (server *Server) postCheck(w http.ResponseWriter, r *http.Request) {
+	if !server.TriggerCheck() {
+		writeJson(w, http.StatusOK, checkResponse{Queued: false, Message: "A check is already queued"})
+		return
+	}
+
+	log.Info().Str("remote", r.RemoteAddr).Msg("Check requested through the HTTP API")
+	writeJson(w, http.StatusAccepted, checkResponse{Queued: true, Message: "Check queued"})
+}
+
+func (server *Server) postTestNotification(w http.ResponseWriter, r *http.Request) {
+	var request testNotificationRequest
+
+	// An empty body sends to every target
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	log.Info().Str("target", request.Target).Str("remote", r.RemoteAddr).Msg("Test notification requested through the HTTP API")
+
+	sent, err := server.Notifier.SendTest(request.Target)
+	if err != nil && sent == 0 {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if err != nil {
+		writeJson(w, http.StatusBadGateway, testNotificationResponse{Sent: sent, Error: err.Error()})
+		return
+	}
+
+	writeJson(w, http.StatusOK, testNotificationResponse{Sent: sent})
+}
+
+func newAppResponse(app *types.App, ignoreRules []database.Rules) appResponse {
+	return appResponse{
+		Instance:             app.Instance,
+		ServerName:           app.ServerName,
+		Urn:                  app.Urn,
+		Name:                 app.Name,
+		Appstore:             app.Appstore.Name,
+		AppstoreSlug:         app.Appstore.Slug,
+		Version:              app.Version,
+		LatestVersion:        app.LatestVersion,
+		DockerVersion:        app.DockerVersion,
+		CurrentDockerVersion: app.CurrentDockerVersion,
+		UpdateAvailable:      !app.PendingSince.IsZero(),
+		UpdateClass:          app.UpdateClass,
+		AppUrl:               utils.GetAppUrl(app),
+		PendingSince:         timePtr(app.PendingSince),
+		LatestSeenAt:         timePtr(app.LatestSeenAt),
+		NotifiedAt:           timePtr(app.NotifiedAt),
+		Ignored:              !app.PendingSince.IsZero() && rules.Match(ignoreRules, app) != nil,
+	}
+}
+
+func timePtr(value time.Time) *time.Time {
+	if value.IsZero() {
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,21 @@
+package web
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed dashboard.html
+var dashboardHtml []byte
+
+func (server *Server) getDashboard(w http.ResponseWriter, r *http.Request) {
+	// The page holds no data, with a token it asks for it and sends it with every API request
+	if server.Username != "" && !server.isAuthorized(r) {
+		server.requireAuth(nil)(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Write(dashboardHtml)
+}
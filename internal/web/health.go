@@ -0,0 +1,78 @@
+package web
+
+import (
+	"net/http"
+	"time"
+	"tipimate/internal/database"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Health statuses
+const (
+	HealthOk        = "ok"
+	HealthUnhealthy = "unhealthy"
+)
+
+type healthResponse struct {
+	Status   string                 `json:"status"`
+	Database string                 `json:"database,omitempty"`
+	Servers  []serverHealthResponse `json:"servers,omitempty"`
+}
+
+type serverHealthResponse struct {
+	Instance      string     `json:"instance"`
+	Ready         bool       `json:"ready"`
+	LastSuccessAt *time.Time `json:"lastSuccessAt,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+}
+
+func (server *Server) getLiveness(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, healthResponse{Status: HealthOk})
+}
+
+func (server *Server) getReadiness(w http.ResponseWriter, r *http.Request) {
+	response := healthResponse{
+		Status:   HealthOk,
+		Database: HealthOk,
+		Servers:  []serverHealthResponse{},
+	}
+
+	err := database.CheckWritable(server.Database)
+	if err != nil {
+		log.Warn().Err(err).Msg("Database is not writable")
+		response.Status = HealthUnhealthy
+		response.Database = err.Error()
+	}
+
+	now := time.Now()
+
+	for _, instanceMonitor := range server.Monitors {
+		status := instanceMonitor.Status()
+
+		// Servers get the same grace period after startup as between two checks
+		lastSuccess := status.LastSuccessAt
+		if lastSuccess.IsZero() {
+			lastSuccess = server.startedAt
+		}
+
+		ready := server.MaxCheckAge <= 0 || now.Sub(lastSuccess) <= server.MaxCheckAge
+		if !ready {
+			response.Status = HealthUnhealthy
+		}
+
+		response.Servers = append(response.Servers, serverHealthResponse{
+			Instance:      status.Instance,
+			Ready:         ready,
+			LastSuccessAt: timePtr(status.LastSuccessAt),
+			LastError:     status.LastError,
+		})
+	}
+
+	if response.Status != HealthOk {
+		writeJson(w, http.StatusServiceUnavailable, response)
+		return
+	}
+
+	writeJson(w, http.StatusOK, response)
+}
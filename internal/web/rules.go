@@ -0,0 +1,125 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"tipimate/internal/database"
+	"tipimate/internal/rules"
+	"tipimate/internal/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+type ruleRequest struct {
+	Urn      string `json:"urn"`
+	Appstore string `json:"appstore"`
+	Server   string `json:"server"`
+	Version  string `json:"version"`
+	For      string `json:"for"`
+	Reason   string `json:"reason"`
+}
+
+type ruleResponse struct {
+	Id        uint       `json:"id"`
+	Kind      string     `json:"kind"`
+	Urn       string     `json:"urn,omitempty"`
+	Appstore  string     `json:"appstore,omitempty"`
+	Server    string     `json:"server,omitempty"`
+	Version   string     `json:"version,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+func (server *Server) getRules(w http.ResponseWriter, r *http.Request) {
+	ignoreRules, err := rules.GetRules(server.Database, r.URL.Query().Get("all") == "true")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get ignore rules")
+		writeError(w, http.StatusInternalServerError, "Failed to get ignore rules")
+		return
+	}
+
+	response := []ruleResponse{}
+	for _, rule := range ignoreRules {
+		response = append(response, newRuleResponse(&rule))
+	}
+
+	writeJson(w, http.StatusOK, response)
+}
+
+func (server *Server) postRule(w http.ResponseWriter, r *http.Request) {
+	var request ruleRequest
+
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	rule := database.Rules{
+		Urn:      request.Urn,
+		Appstore: request.Appstore,
+		Server:   request.Server,
+		Version:  request.Version,
+		Reason:   request.Reason,
+	}
+
+	// Rules with a duration are snoozes
+	if request.For != "" {
+		duration, err := utils.ParseDuration(request.For)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid duration "+request.For)
+			return
+		}
+		until := time.Now().Add(duration)
+		rule.Until = &until
+	}
+
+	err = rules.AddRule(server.Database, &rule)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.Info().Uint("rule", rule.ID).Str("kind", rules.GetKind(&rule)).Str("urn", rule.Urn).Str("remote", r.RemoteAddr).Msg("Ignore rule added through the HTTP API")
+	writeJson(w, http.StatusCreated, newRuleResponse(&rule))
+}
+
+func (server *Server) deleteRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid rule id")
+		return
+	}
+
+	count, err := rules.DeleteRules(server.Database, []uint{uint(id)})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to delete ignore rule")
+		writeError(w, http.StatusInternalServerError, "Failed to delete ignore rule")
+		return
+	}
+
+	if count == 0 {
+		writeError(w, http.StatusNotFound, "Rule not found")
+		return
+	}
+
+	log.Info().Uint64("rule", id).Str("remote", r.RemoteAddr).Msg("Ignore rule deleted through the HTTP API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newRuleResponse(rule *database.Rules) ruleResponse {
+	return ruleResponse{
+		Id:        rule.ID,
+		Kind:      rules.GetKind(rule),
+		Urn:       rule.Urn,
+		Appstore:  rule.Appstore,
+		Server:    rule.Server,
+		Version:   rule.Version,
+		Until:     rule.Until,
+		Reason:    rule.Reason,
+		CreatedAt: rule.CreatedAt,
+	}
+}
@@ -0,0 +1,167 @@
+package web
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"tipimate/internal/alerts"
+	"tipimate/internal/monitor"
+	"tipimate/internal/types"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+func NewServer(config types.HttpConfig, monitors []*monitor.Monitor, notifier *alerts.Alerts, db *gorm.DB, triggerCheck func() bool) *Server {
+	return &Server{
+		Address:       config.Address,
+		HealthAddress: config.HealthAddress,
+		MaxCheckAge:   config.MaxCheckAge,
+		Token:         config.Token,
+		Username:      config.Username,
+		Password:      config.Password,
+		Monitors:      monitors,
+		Notifier:      notifier,
+		Database:      db,
+		TriggerCheck:  triggerCheck,
+		startedAt:     time.Now(),
+	}
+}
+
+type Server struct {
+	Address       string
+	HealthAddress string
+	MaxCheckAge   time.Duration
+	Token         string
+	Username      string
+	Password      string
+	Monitors      []*monitor.Monitor
+	Notifier      *alerts.Alerts
+	Database      *gorm.DB
+	TriggerCheck  func() bool
+	startedAt     time.Time
+}
+
+func (server *Server) Start() error {
+	log.Info().Str("address", server.Address).Msg("Starting HTTP server")
+
+	// The dashboard controls the runtipi apps, so it is never served without credentials
+	if server.Token == "" && server.Username == "" {
+		return errors.New("the HTTP server needs a token or a username and password")
+	}
+
+	httpServer := &http.Server{
+		Addr:              server.Address,
+		Handler:           server.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return httpServer.ListenAndServe()
+}
+
+func (server *Server) StartHealth() error {
+	log.Info().Str("address", server.HealthAddress).Msg("Starting health server")
+
+	httpServer := &http.Server{
+		Addr:              server.HealthAddress,
+		Handler:           server.healthRoutes(http.NewServeMux()),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return httpServer.ListenAndServe()
+}
+
+func (server *Server) healthRoutes(mux *http.ServeMux) *http.ServeMux {
+	// Probes can't log in, and the answers don't contain anything sensitive
+	mux.HandleFunc("GET /healthz", server.getLiveness)
+	mux.HandleFunc("GET /readyz", server.getReadiness)
+	return mux
+}
+
+func (server *Server) routes() http.Handler {
+	mux := server.healthRoutes(http.NewServeMux())
+
+	mux.HandleFunc("GET /{$}", server.getDashboard)
+	mux.HandleFunc("GET /api/apps", server.requireAuth(server.getApps))
+	mux.HandleFunc("GET /api/updates", server.requireAuth(server.getUpdates))
+	mux.HandleFunc("GET /api/status", server.requireAuth(server.getStatus))
+	mux.HandleFunc("POST /api/check", server.requireAuth(requireJson(server.postCheck)))
+	mux.HandleFunc("GET /api/rules", server.requireAuth(server.getRules))
+	mux.HandleFunc("POST /api/rules", server.requireAuth(requireJson(server.postRule)))
+	mux.HandleFunc("DELETE /api/rules/{id}", server.requireAuth(requireJson(server.deleteRule)))
+	mux.HandleFunc("POST /api/notifications/test", server.requireAuth(requireJson(server.postTestNotification)))
+	mux.Handle("GET /metrics", server.requireAuth(promhttp.Handler().ServeHTTP))
+
+	return mux
+}
+
+func (server *Server) requireAuth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if server.isAuthorized(r) {
+			handler(w, r)
+			return
+		}
+
+		// Only basic auth can be answered by the browser login prompt
+		if server.Username != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="tipimate", charset="UTF-8"`)
+		}
+
+		writeError(w, http.StatusUnauthorized, "Invalid or missing credentials")
+	}
+}
+
+// Browsers can't send a JSON content type cross-site without a CORS preflight, which
+// keeps other pages from using cached basic auth credentials to change anything
+func requireJson(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func (server *Server) isAuthorized(r *http.Request) bool {
+	if server.Token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && secureEqual(token, server.Token) {
+			return true
+		}
+	}
+
+	if server.Username != "" {
+		username, password, ok := r.BasicAuth()
+		if ok && secureEqual(username, server.Username) && secureEqual(password, server.Password) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func secureEqual(value string, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(value), []byte(expected)) == 1
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to write HTTP response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJson(w, status, errorResponse{Error: message})
+}